@@ -1,9 +1,18 @@
 package bencode
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func DecodingError(t *testing.T, typ, msg, exp, recv string) {
@@ -18,7 +27,7 @@ func it(t *testing.T, in string, exp int64, exp_err bool) {
 	i, err := d.Decode()
 	if !exp_err {
 		if err != nil {
-			DecodingError(t, "int", "unexpected error", dsumm(in, exp), err.String())
+			DecodingError(t, "int", "unexpected error", dsumm(in, exp), err.Error())
 		}
 		if i != exp {
 			DecodingError(t, "int", "unexpected result", strconv.FormatInt(exp, 10), dsumm(in, i))
@@ -36,6 +45,7 @@ func TestInteger(t *testing.T) {
 	it(t, "i0e", 0, false)
 	it(t, "ie", 0, true)
 	it(t, "i-e", 0, true)
+	it(t, "i-0e", 0, true)
 	it(t, "i15155", 0, true)
 	it(t, "55", 55, true)
 }
@@ -48,7 +58,7 @@ func st(t *testing.T, in string, exp string, exp_err bool) {
 	s, err := d.Decode()
 	if !exp_err {
 		if err != nil {
-			DecodingError(t, "string", "unexpected error", dsumm(in, exp), err.String())
+			DecodingError(t, "string", "unexpected error", dsumm(in, exp), err.Error())
 		}
 		if s != exp {
 			DecodingError(t, "string", "unexpected result", exp, dsumm(in, s))
@@ -73,7 +83,7 @@ func lt(t *testing.T, in string, exp []interface{}, exp_err bool) {
 	l, err := d.Decode()
 	if !exp_err {
 		if err != nil {
-			DecodingError(t, "list", "unexpected error", dsumm(in, exp), err.String())
+			DecodingError(t, "list", "unexpected error", dsumm(in, exp), err.Error())
 		}
 		switch l.(type) {
 		case nil:
@@ -116,7 +126,7 @@ func dt(t *testing.T, in string, exp map[string]interface{}, exp_err bool) {
 	dict, err := d.Decode()
 	if !exp_err {
 		if err != nil {
-			DecodingError(t, "list", "unexpected error", dsumm(in, exp), err.String())
+			DecodingError(t, "list", "unexpected error", dsumm(in, exp), err.Error())
 		}
 		switch dict.(type) {
 		case map[string]interface{}:
@@ -151,3 +161,1291 @@ func TestDict(t *testing.T) {
 	dt(t, "d4:highi5e", map[string]interface{}{}, true)
 	dt(t, "d5:highi5ee", map[string]interface{}{}, true)
 }
+
+func TestRawDictValue(t *testing.T) {
+	in := "d1:bi1e1:ald1:x1:yee1:c0:e"
+	raw, err := RawDictValue([]byte(in), "a")
+	if err != nil {
+		t.Fatalf("RawDictValue: unexpected error %v", err)
+	}
+	if string(raw) != "ld1:x1:yee" {
+		t.Errorf("RawDictValue: expected %s, got %s", "ld1:x1:yee", raw)
+	}
+	if _, err = RawDictValue([]byte(in), "z"); err == nil {
+		t.Errorf("RawDictValue: expected error for missing key")
+	}
+	if _, err = RawDictValue([]byte("d1:ai1e"), "z"); err == nil {
+		t.Errorf("RawDictValue: expected error for unterminated dict")
+	}
+	if _, err = RawDictValue([]byte("d1:ai1e1:bi2e1:ai3ee"), "a"); !errors.Is(err, ErrorDuplicateKey) {
+		t.Errorf("RawDictValue: expected ErrorDuplicateKey for repeated key, got %v", err)
+	}
+	if raw, err = RawDictValue([]byte("d1:ai1e1:bi2e1:bi3ee"), "a"); err != nil || string(raw) != "i1e" {
+		t.Errorf("RawDictValue: other repeated keys changed the result to %q (%v)", raw, err)
+	}
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	for in, exp := range map[string]interface{}{
+		"0:": "",
+		"le": []interface{}{},
+		"de": map[string]interface{}{},
+		"d1:a0:1:ble1:dd1:cdeee": map[string]interface{}{
+			"a": "",
+			"b": []interface{}{},
+			"d": map[string]interface{}{"c": map[string]interface{}{}},
+		},
+	} {
+		if b, err := Encode(exp); err != nil || string(b) != in {
+			t.Errorf("Encoding %#v: expected %s, got %s", exp, in, b)
+		}
+	}
+}
+
+func TestEncodeUnsupported(t *testing.T) {
+	for _, in := range []interface{}{
+		3.14,
+		[]interface{}{1, 2.5},
+		map[string]interface{}{"a": struct{}{}},
+		[]string{"a"},
+	} {
+		enc := NewEncoder()
+		if err := enc.Encode(in); err == nil {
+			t.Errorf("Encoding %#v: expected error, got %s", in, enc.Bytes)
+		} else if len(enc.Bytes) != 0 {
+			t.Errorf("Encoding %#v: expected no output, got %s", in, enc.Bytes)
+		}
+		if b, err := Encode(in); err == nil || b != nil {
+			t.Errorf("Encode(%#v): expected error, got %s", in, b)
+		}
+	}
+}
+
+func TestEncodeIntegerKinds(t *testing.T) {
+	type port uint16
+	for _, c := range []struct {
+		in  interface{}
+		exp string
+	}{
+		{int(-1), "i-1e"},
+		{int8(-128), "i-128e"},
+		{int16(32767), "i32767e"},
+		{int32(-5), "i-5e"},
+		{int64(math.MinInt64), "i-9223372036854775808e"},
+		{uint(7), "i7e"},
+		{uint8(255), "i255e"},
+		{uint16(6881), "i6881e"},
+		{uint32(math.MaxUint32), "i4294967295e"},
+		{uint64(math.MaxInt64), "i9223372036854775807e"},
+		{uintptr(1), "i1e"},
+		{port(80), "i80e"},
+	} {
+		if b, err := Encode(c.in); err != nil || string(b) != c.exp {
+			t.Errorf("Encoding %T(%v): expected %s, got %s (%v)", c.in, c.in, c.exp, b, err)
+		}
+	}
+
+	if b, err := Encode(uint64(math.MaxInt64 + 1)); err == nil {
+		t.Errorf("Encoding uint64 overflow: expected error, got %s", b)
+	}
+}
+
+func TestWriterEncoder(t *testing.T) {
+	var list []interface{}
+	for i := 0; i < 10000; i++ {
+		list = append(list, i, fmt.Sprintf("piece%d", i), map[string]interface{}{"b": i, "a": []interface{}{}})
+	}
+
+	exp, err := Encode(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	enc := NewWriterEncoder(&buf)
+	if err = enc.Encode(list); err != nil {
+		t.Fatalf("WriterEncoder: unexpected error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), exp) {
+		t.Errorf("WriterEncoder: output differs from in-memory encoder")
+	}
+	if len(enc.Bytes) != 0 {
+		t.Errorf("WriterEncoder: unexpected output in Bytes")
+	}
+}
+
+func TestDecodeEmpty(t *testing.T) {
+	for _, in := range [][]byte{nil, {}} {
+		res, err := NewDecoder(in).DecodeAll()
+		if err != nil || res == nil || len(res) != 0 {
+			t.Errorf("DecodeAll(%#v): expected empty result, got %#v (%v)", in, res, err)
+		}
+		if o, err := NewDecoder(in).Decode(); err != io.EOF {
+			t.Errorf("Decode(%#v): expected io.EOF, got %#v (%v)", in, o, err)
+		}
+	}
+
+	res, err := NewDecoder([]byte("i1e3:abc")).DecodeAll()
+	if err != nil || len(res) != 2 || res[0] != int64(1) || res[1] != "abc" {
+		t.Errorf("DecodeAll: unexpected result %#v (%v)", res, err)
+	}
+}
+
+func TestDecodeSingle(t *testing.T) {
+	for in, ok := range map[string]bool{
+		"i5e":      true,
+		"i5e\r\n":  true,
+		"d1:ai1ee": true,
+		"i5ei6e":   false,
+		"i5e x":    false,
+		"":         false,
+	} {
+		o, err := DecodeSingle([]byte(in))
+		if ok && (err != nil || o == nil) {
+			t.Errorf("DecodeSingle(%q): unexpected error %v", in, err)
+		} else if !ok && err == nil {
+			t.Errorf("DecodeSingle(%q): expected error, got %#v", in, o)
+		}
+	}
+}
+
+func TestIsCanonical(t *testing.T) {
+	for in, exp := range map[string]bool{
+		"i5e":                     true,
+		"i-5e":                    true,
+		"i0e":                     true,
+		"0:":                      true,
+		"03:abc":                  false,
+		"d1:a1:b1:b1:ce":          true,
+		"d1:b1:c1:a1:be":          false,
+		"d1:a1:b1:a1:be":          false,
+		"ld1:ai1e1:bi2eeli3eee":   true,
+		"ld1:bi1e1:ai2eee":        false,
+		"d2:ab0:1:bd1:y0:1:x0:ee": false,
+		"i5e\n":                   false,
+	} {
+		ok, err := IsCanonical([]byte(in))
+		if err != nil {
+			t.Errorf("IsCanonical(%q): unexpected error %v", in, err)
+		} else if ok != exp {
+			t.Errorf("IsCanonical(%q): expected %v, got %v", in, exp, ok)
+		}
+	}
+	for _, in := range []string{"i5", "i-0e"} {
+		if _, err := IsCanonical([]byte(in)); err == nil {
+			t.Errorf("IsCanonical(%q): expected error for invalid input", in)
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	in := "d4:spaml1:b1:ae3:cowd1:z0:1:ai-3ee1:xlee"
+	exp := "d3:cowd1:ai-3e1:z0:e4:spaml1:b1:ae1:xlee"
+	b, err := Canonicalize([]byte(in))
+	if err != nil {
+		t.Fatalf("Canonicalize: unexpected error %v", err)
+	}
+	if string(b) != exp {
+		t.Errorf("Canonicalize: expected %s, got %s", exp, b)
+	}
+	if ok, _ := IsCanonical(b); !ok {
+		t.Errorf("Canonicalize: result %s is not canonical", b)
+	}
+	if b2, err := Canonicalize(b); err != nil || !bytes.Equal(b, b2) {
+		t.Errorf("Canonicalize: not idempotent, got %s (%v)", b2, err)
+	}
+}
+
+func TestDecoderReset(t *testing.T) {
+	d := NewDecoder([]byte("i1e"))
+	if _, err := d.DecodeAll(); err != nil || !d.Consumed {
+		t.Fatalf("DecodeAll: unexpected error %v", err)
+	}
+	d.Reset([]byte("3:abc"))
+	if d.Consumed {
+		t.Errorf("Reset: decoder still consumed")
+	}
+	if o, err := d.Decode(); err != nil || o != "abc" {
+		t.Errorf("Reset: expected abc, got %#v (%v)", o, err)
+	}
+	d.Reset(nil)
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Reset: expected io.EOF, got %v", err)
+	}
+}
+
+var (
+	benchDoc     = []byte("d8:intervali1800e5:peers12:abcdefghijkle")
+	benchDecoder *Decoder //keeps decoders on the heap like real callers do
+)
+
+func BenchmarkDecoderNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchDecoder = NewDecoder(benchDoc)
+		if _, err := benchDecoder.Decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderPooled(b *testing.B) {
+	pool := sync.Pool{New: func() interface{} { return NewDecoder(nil) }}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := pool.Get().(*Decoder)
+		d.Reset(benchDoc)
+		if _, err := d.Decode(); err != nil {
+			b.Fatal(err)
+		}
+		pool.Put(d)
+	}
+}
+
+func TestZeroCopyStrings(t *testing.T) {
+	in := []byte("d4:listl3:abc0:e6:pieces5:x\x00y\xffze")
+	exp, err := NewDecoder(in).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(in)
+	d.ZeroCopyStrings = true
+	obj, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obj, exp) {
+		t.Errorf("Zero copy decoding returned %#v, expected %#v", obj, exp)
+	}
+}
+
+func TestDecodeIntern(t *testing.T) {
+	docs := []string{
+		"d4:infod6:lengthi5e4:name3:abc6:pieces300:" + strings.Repeat("x", 300) + "ee",
+		"d4:infod6:lengthi7e4:name3:abc6:pieces1:yee",
+		"l3:abc3:abc0:e",
+	}
+	intern := make(map[string]string)
+	for _, doc := range docs {
+		exp, err := NewDecoder([]byte(doc)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewDecoder([]byte(doc))
+		d.Intern = intern
+		obj, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(obj, exp) {
+			t.Errorf("Interned decoding of %q returned %#v, expected %#v", doc, obj, exp)
+		}
+	}
+	for _, s := range []string{"info", "length", "name", "abc", "pieces", "y", ""} {
+		if _, ok := intern[s]; !ok {
+			t.Errorf("Intern: %q wasn't interned", s)
+		}
+	}
+	if len(intern) != 7 {
+		t.Errorf("Intern: expected 7 strings, got %d", len(intern))
+	}
+}
+
+var benchTorrents = func() [][]byte {
+	docs := make([][]byte, 100)
+	for i := range docs {
+		docs[i] = []byte(fmt.Sprintf("d8:announce20:http://tracker/annce4:infod6:lengthi%de4:name8:file.txt12:piece lengthi16384e6:pieces20:%020dee", i, i))
+	}
+	return docs
+}()
+
+func benchmarkDecodeTorrents(b *testing.B, intern map[string]string) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, doc := range benchTorrents {
+			d := NewDecoder(doc)
+			d.Intern = intern
+			if _, err := d.Decode(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkDecodeTorrents(b *testing.B) { benchmarkDecodeTorrents(b, nil) }
+func BenchmarkDecodeTorrentsInterned(b *testing.B) {
+	benchmarkDecodeTorrents(b, make(map[string]string))
+}
+
+var benchPieces = []byte(fmt.Sprintf("d6:pieces%d:%se", 20*4096, strings.Repeat("x", 20*4096)))
+
+func benchmarkDecodePieces(b *testing.B, zeroCopy bool) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		d := NewDecoder(benchPieces)
+		d.ZeroCopyStrings = zeroCopy
+		if _, err := d.Decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodePieces(b *testing.B)         { benchmarkDecodePieces(b, false) }
+func BenchmarkDecodePiecesZeroCopy(b *testing.B) { benchmarkDecodePieces(b, true) }
+
+func TestDump(t *testing.T) {
+	pieces := strings.Repeat("\xdb\x6a\x48\xb7\x00", 200)
+	in, err := Encode(map[string]interface{}{
+		"announce": "http://tracker/announce",
+		"info": map[string]interface{}{
+			"name":   "test",
+			"length": 4,
+			"pieces": pieces,
+			"files":  []interface{}{"a", map[string]interface{}{}, []interface{}{}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err = Dump(in, &b); err != nil {
+		t.Fatalf("Dump: unexpected error %v", err)
+	}
+	exp := `{
+  "announce": "http://tracker/announce",
+  "info": {
+    "files": [
+      "a",
+      {},
+      []
+    ],
+    "length": 4,
+    "name": "test",
+    "pieces": <1000 bytes: db6a48b700db6a48...>
+  }
+}
+`
+	if b.String() != exp {
+		t.Errorf("Dump: expected\n%s\ngot\n%s", exp, b.String())
+	}
+	if strings.Contains(b.String(), pieces[:5]) {
+		t.Errorf("Dump: raw pieces in output")
+	}
+
+	if err = Dump([]byte("i5"), &b); err == nil {
+		t.Errorf("Dump: expected error for invalid input")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	in, err := Encode(map[string]interface{}{
+		"comment": "Grüße",
+		"info": map[string]interface{}{
+			"length": 691011584,
+			"pieces": "\xdb\x6a\x48\xb7\x00\xff",
+			"files":  []interface{}{"a", -1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := ToJSON(in)
+	if err != nil {
+		t.Fatalf("ToJSON: unexpected error %v", err)
+	}
+	exp := `{"comment":"Grüße","info":{"files":["a",-1],"length":691011584,"pieces":{"$base64":"22pItwD/"}}}`
+	if string(j) != exp {
+		t.Errorf("ToJSON: expected %s, got %s", exp, j)
+	}
+
+	b, err := FromJSON(j)
+	if err != nil {
+		t.Fatalf("FromJSON: unexpected error %v", err)
+	}
+	if !bytes.Equal(b, in) {
+		t.Errorf("FromJSON: round trip changed %s to %s", in, b)
+	}
+
+	//binary keys, like the info hashes of a scrape
+	in, err = Encode(map[string]interface{}{
+		"files": map[string]interface{}{
+			"\x12\x34\xff\x00\x56\x78\x9a\xbc\xde\xf0\x12\x34\x56\x78\x9a\xbc\xde\xf0\x12\x34": map[string]interface{}{"complete": 1},
+			"$base64:x": "",
+		},
+		"wrapper": map[string]interface{}{"$base64": "abc"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j, err = ToJSON(in); err != nil {
+		t.Fatalf("ToJSON: unexpected error %v", err)
+	}
+	exp = `{"files":{"$base64:EjT/AFZ4mrze8BI0VniavN7wEjQ=":{"complete":1},"$base64:JGJhc2U2NDp4":""},"wrapper":{"$base64:JGJhc2U2NA==":"abc"}}`
+	if string(j) != exp {
+		t.Errorf("ToJSON: expected %s, got %s", exp, j)
+	}
+	if b, err = FromJSON(j); err != nil || !bytes.Equal(b, in) {
+		t.Errorf("FromJSON: round trip changed %q to %q (%v)", in, b, err)
+	}
+
+	if j, err = ToJSON([]byte("d7:$base643:abce")); err != nil {
+		t.Fatalf("ToJSON: unexpected error %v", err)
+	}
+	if b, err = FromJSON(j); err != nil || string(b) != "d7:$base643:abce" {
+		t.Errorf("FromJSON: round trip changed a $base64 dict to %q (%v)", b, err)
+	}
+
+	for _, bad := range []string{`1.5`, `true`, `null`, `[1, {"a": null}]`, `{"$base64": "!!"}`, `1 2`, `{"$base64:!!": 1}`, `{"a": 1, "$base64:YQ==": 2}`} {
+		if _, err := FromJSON([]byte(bad)); err == nil {
+			t.Errorf("FromJSON(%s): expected error", bad)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	nested := func(n int) []byte {
+		return []byte(strings.Repeat("l", n) + strings.Repeat("e", n))
+	}
+	if _, err := NewDecoder(nested(DefaultMaxDepth)).Decode(); err != nil {
+		t.Errorf("Decode: unexpected error at default depth %v", err)
+	}
+	if _, err := NewDecoder(nested(100000)).Decode(); !errors.Is(err, ErrorMaxDepth) {
+		t.Errorf("Decode: expected ErrorMaxDepth, got %v", err)
+	}
+
+	d := NewDecoder([]byte("d1:ald1:alleeee"))
+	d.MaxDepth = 3
+	if _, err := d.Decode(); !errors.Is(err, ErrorMaxDepth) {
+		t.Errorf("Decode: expected ErrorMaxDepth with MaxDepth 3, got %v", err)
+	}
+	d.Reset([]byte("d1:ald1:a0:eeeli1ee"))
+	if o, err := d.DecodeAll(); err != nil || len(o) != 2 {
+		t.Errorf("DecodeAll: unexpected result %v (%v)", o, err)
+	}
+}
+
+func TestMaxStringLen(t *testing.T) {
+	for _, in := range []string{"999999999999:abc", "99999999999999999999999:abc"} {
+		if o, err := NewDecoder([]byte(in)).Decode(); err == nil {
+			t.Errorf("Decode(%s): expected error, got %#v", in, o)
+		}
+	}
+
+	d := NewDecoder([]byte("999999999999:abc"))
+	d.MaxStringLen = 4
+	if _, err := d.Decode(); !errors.Is(err, ErrorStringTooLong) {
+		t.Errorf("Decode: expected ErrorStringTooLong, got %v", err)
+	}
+	d.Reset([]byte("l4:abcd5:abcdee"))
+	if _, err := d.Decode(); !errors.Is(err, ErrorStringTooLong) {
+		t.Errorf("Decode: expected ErrorStringTooLong, got %v", err)
+	}
+	d.Reset([]byte("l4:abcd0:e"))
+	if o, err := d.Decode(); err != nil {
+		t.Errorf("Decode: unexpected error %v (%#v)", err, o)
+	}
+}
+
+func TestDecoderCloneIntern(t *testing.T) {
+	d := NewDecoder([]byte("ld4:name3:abce3:abce"))
+	d.Intern = make(map[string]string)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		c := d.Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Decode(); err != nil {
+				t.Errorf("Decode: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(d.Intern) != 0 {
+		t.Errorf("Clone: clones shared the Intern map of the original")
+	}
+}
+
+func TestDecoderClone(t *testing.T) {
+	d := NewDecoder([]byte("i1e3:abcli2ee"))
+	if o, err := d.Decode(); err != nil || o != int64(1) {
+		t.Fatalf("Decode: unexpected result %#v (%v)", o, err)
+	}
+	c := d.Clone()
+	if o, err := c.Decode(); err != nil || o != "abc" {
+		t.Errorf("Clone: unexpected result %#v (%v)", o, err)
+	}
+	if _, err := c.DecodeAll(); err != nil || !c.Consumed {
+		t.Errorf("Clone: unexpected error %v", err)
+	}
+	if d.Consumed {
+		t.Errorf("Clone: original consumed by clone")
+	}
+	if o, err := d.Decode(); err != nil || o != "abc" {
+		t.Errorf("Decode: original moved by clone, got %#v (%v)", o, err)
+	}
+}
+
+func TestPeekType(t *testing.T) {
+	d := NewDecoder([]byte("i1e3:abcli2eed1:a0:e"))
+	for _, exp := range []Kind{KindInt, KindString, KindList, KindDict} {
+		k, err := d.PeekType()
+		if err != nil || k != exp {
+			t.Errorf("PeekType: expected %v, got %v (%v)", exp, k, err)
+		}
+		if k2, _ := d.PeekType(); k2 != k {
+			t.Errorf("PeekType: consumed input")
+		}
+		if _, err = d.Decode(); err != nil {
+			t.Fatalf("Decode: unexpected error %v", err)
+		}
+	}
+	if _, err := d.PeekType(); !errors.Is(err, ErrorConsumed) {
+		t.Errorf("PeekType: expected ErrorConsumed, got %v", err)
+	}
+	if _, err := NewDecoder([]byte("x")).PeekType(); err == nil {
+		t.Errorf("PeekType: expected error for invalid byte")
+	}
+}
+
+func TestDecodeTyped(t *testing.T) {
+	d, err := DecodeTyped[map[string]interface{}]([]byte("d1:ai1ee"))
+	if err != nil || d["a"] != int64(1) {
+		t.Errorf("DecodeTyped: unexpected result %#v (%v)", d, err)
+	}
+	if l, err := DecodeTyped[[]interface{}]([]byte("l1:ae")); err != nil || len(l) != 1 {
+		t.Errorf("DecodeTyped: unexpected result %#v (%v)", l, err)
+	}
+	if i, err := DecodeTyped[int64]([]byte("i5e")); err != nil || i != 5 {
+		t.Errorf("DecodeTyped: unexpected result %#v (%v)", i, err)
+	}
+
+	if d, err = DecodeTyped[map[string]interface{}]([]byte("i5e")); err == nil || d != nil {
+		t.Errorf("DecodeTyped: expected error for mismatched type, got %#v", d)
+	}
+	if s, err := DecodeTyped[string]([]byte("le")); err == nil || s != "" {
+		t.Errorf("DecodeTyped: expected error for mismatched type, got %#v", s)
+	}
+	if _, err := DecodeTyped[int]([]byte("i5e")); err == nil {
+		t.Errorf("DecodeTyped: expected error for int target")
+	}
+}
+
+var benchObject = map[string]interface{}{
+	"interval": 1800,
+	"peers":    []interface{}{"10.0.0.1", 6881, "10.0.0.2", 6882},
+	"tracker":  "http://tracker/announce",
+}
+
+func TestAppendEncode(t *testing.T) {
+	exp, err := Encode(benchObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := AppendEncode([]byte("i1e"), benchObject)
+	if err != nil || string(b) != "i1e"+string(exp) {
+		t.Errorf("AppendEncode: expected i1e%s, got %s (%v)", exp, b, err)
+	}
+	if b, err = AppendEncode(b[:3], 1.5); err == nil || string(b) != "i1e" {
+		t.Errorf("AppendEncode: expected error and unchanged buffer, got %s (%v)", b, err)
+	}
+}
+
+func BenchmarkAppendEncode(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		if buf, err = AppendEncode(buf[:0], benchObject); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncoderReset(t *testing.T) {
+	enc := NewEncoder()
+	for _, in := range []interface{}{benchObject, "spam", []interface{}{1, "a"}, benchObject} {
+		exp, err := Encode(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.Reset()
+		if err = enc.Encode(in); err != nil || !bytes.Equal(enc.Bytes, exp) {
+			t.Errorf("Encode after Reset: expected %s, got %s (%v)", exp, enc.Bytes, err)
+		}
+	}
+	c := cap(enc.Bytes)
+	enc.Reset()
+	if len(enc.Bytes) != 0 || cap(enc.Bytes) != c {
+		t.Errorf("Reset: expected empty output with capacity %d, got %d/%d", c, len(enc.Bytes), cap(enc.Bytes))
+	}
+}
+
+func BenchmarkEncoderReset(b *testing.B) {
+	b.ReportAllocs()
+	enc := NewEncoder()
+	for i := 0; i < b.N; i++ {
+		enc.Reset()
+		if err := enc.Encode(benchObject); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Encode(benchObject); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//many small strings and integers, like the file list of a big torrent
+var benchFiles = func() []interface{} {
+	files := make([]interface{}, 1000)
+	for i := range files {
+		files[i] = map[string]interface{}{
+			"length": int64(i) * 16384,
+			"path":   []interface{}{"dir", fmt.Sprintf("file%d.bin", i)},
+		}
+	}
+	return files
+}()
+
+func BenchmarkEncodeStrings(b *testing.B) {
+	b.ReportAllocs()
+	list := make([]interface{}, 1000)
+	for i := range list {
+		list[i] = strings.Repeat("x", 20)
+	}
+	var buf []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		if buf, err = AppendEncode(buf[:0], list); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeIntegers(b *testing.B) {
+	b.ReportAllocs()
+	list := make([]interface{}, 1000)
+	for i := range list {
+		list[i] = int64(i) * 1000003
+	}
+	var buf []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		if buf, err = AppendEncode(buf[:0], list); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeFiles(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	var err error
+	for i := 0; i < b.N; i++ {
+		if buf, err = AppendEncode(buf[:0], benchFiles); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriterEncodeFiles(b *testing.B) {
+	b.ReportAllocs()
+	var w bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		if err := NewWriterEncoder(&w).Encode(benchFiles); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		offset int
+		kind   string
+	}{
+		{"li1ei2e", 7, DecodeErrorNoTerminator},
+		{"i0123e", 1, DecodeErrorLeadingZero},
+		{"5:ab", 0, DecodeErrorLength},
+		{"l1:ax1:be", 4, DecodeErrorSyntax},
+		{"i12a4e", 3, DecodeErrorSyntax},
+		{"i99999999999999999999e", 1, DecodeErrorInteger},
+		{"i", 1, DecodeErrorNoTerminator},
+		{"i-", 2, DecodeErrorNoTerminator},
+		{"l", 1, DecodeErrorNoTerminator},
+		{"d", 1, DecodeErrorNoTerminator},
+		{"lll", 3, DecodeErrorNoTerminator},
+	} {
+		_, err := NewDecoder([]byte(c.in)).Decode()
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Errorf("Decode(%s): expected DecodeError, got %v", c.in, err)
+			continue
+		}
+		if de.Offset != c.offset || de.Kind != c.kind {
+			t.Errorf("Decode(%s): expected %s at %d, got %s at %d (%v)", c.in, c.kind, c.offset, de.Kind, de.Offset, err)
+		}
+	}
+
+	_, err := NewDecoder([]byte("d1:ai1e")).Decode()
+	if !errors.Is(err, ErrorNoTerminator) {
+		t.Errorf("Decode: expected ErrorNoTerminator, got %v", err)
+	}
+	_, err = NewDecoder(nil).Decode()
+	if err != io.EOF {
+		t.Errorf("Decode: expected io.EOF, got %v", err)
+	}
+}
+
+func TestPackageDecode(t *testing.T) {
+	for _, in := range []string{"i5e", "i5ei6e", "4:spam", "l1:ai1ee", "d1:ai1ee", "", "i5", "x", "d1:ai1e"} {
+		exp, experr := NewDecoder([]byte(in)).Decode()
+		o, err := Decode([]byte(in))
+		if !reflect.DeepEqual(o, exp) || fmt.Sprint(err) != fmt.Sprint(experr) {
+			t.Errorf("Decode(%q): expected %#v (%v), got %#v (%v)", in, exp, experr, o, err)
+		}
+	}
+}
+
+//documents used by the unit tests above
+var fuzzSeeds = []string{
+	"i23e", "i124145124e", "i0e", "ie", "i-e", "i15155", "55", "i-0e", "i0123e",
+	"5:hello", "6:world", "0:", "03:abc",
+	"li124145124ee", "li15155ee", "le", "li15155e", "lli4ei5eeli6ei7eee",
+	"d4:blahi124145124ee", "d5:hello5:worlde", "de", "d4:highi5e", "d5:highi5ee",
+	"d1:b1:c1:a1:be", "d3:key1:a3:key1:be", "d4:spaml1:a1:bee1:xd4:fick1:oe",
+	"i5ei6e", "999999999999:abc",
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := NewDecoder(data)
+		d.Decode()
+		NewDecoder(data).DecodeAll()
+		DecodeSingle(data)
+	})
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		o, err := Decode(data)
+		if err != nil {
+			return
+		}
+		b, err := Encode(o)
+		if err != nil {
+			t.Fatalf("Couldn't encode decoded %q: %v", data, err)
+		}
+		o2, err := DecodeSingle(b)
+		if err != nil {
+			t.Fatalf("Couldn't decode re-encoded %q: %v", b, err)
+		}
+		if !reflect.DeepEqual(o, o2) {
+			t.Fatalf("Round trip of %q changed %#v to %#v", data, o, o2)
+		}
+	})
+}
+
+func TestDecodeBigInts(t *testing.T) {
+	in := []byte("li9223372036854775808ei-99999999999999999999ei5ee")
+	if _, err := NewDecoder(in).Decode(); err == nil {
+		t.Errorf("Decode: expected overflow error without BigInts")
+	}
+
+	d := NewDecoder(in)
+	d.BigInts = true
+	o, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	l := o.([]interface{})
+	max := new(big.Int).Add(big.NewInt(math.MaxInt64), big.NewInt(1))
+	if b, ok := l[0].(*big.Int); !ok || b.Cmp(max) != 0 {
+		t.Errorf("Decode: expected *big.Int %s, got %#v", max, l[0])
+	}
+	if b, ok := l[1].(*big.Int); !ok || b.String() != "-99999999999999999999" {
+		t.Errorf("Decode: expected *big.Int -99999999999999999999, got %#v", l[1])
+	}
+	if i, ok := l[2].(int64); !ok || i != 5 {
+		t.Errorf("Decode: expected int64 5, got %#v", l[2])
+	}
+}
+
+func TestDiff(t *testing.T) {
+	torrent := func(comment string, pieceLength int64, pieces string) []byte {
+		b, err := Encode(map[string]interface{}{
+			"announce": "http://tracker.example/announce",
+			"comment":  comment,
+			"info": map[string]interface{}{
+				"name":         "a.txt",
+				"length":       int64(10),
+				"piece length": pieceLength,
+				"pieces":       pieces,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	pieces := strings.Repeat("\x00\xff", 10)
+	a := torrent("first", 16384, pieces)
+	b := torrent("second", 32768, pieces)
+
+	diffs, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []DiffEntry{
+		{"comment", DiffChanged, `"first"`, `"second"`},
+		{"info/piece length", DiffChanged, "16384", "32768"},
+	}
+	if !reflect.DeepEqual(diffs, exp) {
+		t.Errorf("Diff: expected %v, got %v", exp, diffs)
+	}
+
+	if diffs, err = Diff(a, a); err != nil || len(diffs) != 0 {
+		t.Errorf("Diff: expected no differences, got %v (%v)", diffs, err)
+	}
+
+	diffs, err = Diff(a, torrent("first", 16384, strings.Repeat("\x01", 20)))
+	if err != nil || len(diffs) != 1 || diffs[0].Path != "info/pieces" {
+		t.Fatalf("Diff: expected a change of info/pieces, got %v (%v)", diffs, err)
+	}
+	if !strings.HasPrefix(diffs[0].Old, "<20 bytes, sha1 ") {
+		t.Errorf("Diff: expected binary value summary, got %s", diffs[0].Old)
+	}
+
+	diffs, err = Diff([]byte("d1:ai1e1:bli1eee"), []byte("d1:bli1ei2ee1:ci3ee"))
+	exp = []DiffEntry{
+		{"a", DiffRemoved, "1", ""},
+		{"b/1", DiffAdded, "", "2"},
+		{"c", DiffAdded, "", "3"},
+	}
+	if err != nil || !reflect.DeepEqual(diffs, exp) {
+		t.Errorf("Diff: expected %v, got %v (%v)", exp, diffs, err)
+	}
+
+	if _, err = Diff([]byte("i1e"), []byte("i1")); err == nil {
+		t.Errorf("Diff: expected decoding error")
+	}
+}
+
+func TestDecodeDuplicateKey(t *testing.T) {
+	in := []byte("d3:key1:a3:key1:be")
+	o, err := NewDecoder(in).Decode()
+	if err != nil || !reflect.DeepEqual(o, map[string]interface{}{"key": "b"}) {
+		t.Errorf("Decode: expected the last value in lenient mode, got %#v (%v)", o, err)
+	}
+
+	for _, c := range []struct {
+		in     string
+		offset int
+	}{
+		{"d3:key1:a3:key1:be", 9},
+		{"ld1:ai1e1:bi2e1:ai3eee", 14},
+	} {
+		d := NewDecoder([]byte(c.in))
+		d.Strict = true
+		_, err = d.Decode()
+		var de *DecodeError
+		if !errors.Is(err, ErrorDuplicateKey) || !errors.As(err, &de) || de.Offset != c.offset {
+			t.Errorf("Decode(%s): expected ErrorDuplicateKey at %d, got %v", c.in, c.offset, err)
+		}
+	}
+
+	d := NewDecoder([]byte("d1:ad1:ai1ee1:bd1:ai2eee"))
+	d.Strict = true
+	if _, err = d.Decode(); err != nil {
+		t.Errorf("Decode: same key in different dicts rejected: %v", err)
+	}
+
+	d = NewDecoder([]byte("d1:bi1e1:ai2e1:bi3ee"))
+	d.NoDuplicateKeys = true
+	if _, err = d.Decode(); !errors.Is(err, ErrorDuplicateKey) {
+		t.Errorf("Decode: expected ErrorDuplicateKey with NoDuplicateKeys, got %v", err)
+	}
+	d = NewDecoder([]byte("d1:bi1e1:ai2ee"))
+	d.NoDuplicateKeys = true
+	if _, err = d.Decode(); err != nil {
+		t.Errorf("Decode: unsorted keys rejected with NoDuplicateKeys: %v", err)
+	}
+}
+
+func TestDecodeStrictSorted(t *testing.T) {
+	for _, c := range []struct {
+		in     string
+		offset int
+	}{
+		{"d1:b1:c1:a1:be", 7},
+		{"d1:ai1e1:cd2:zz0:2:aa0:ee", 17},
+	} {
+		if _, err := NewDecoder([]byte(c.in)).Decode(); err != nil {
+			t.Errorf("Decode(%s): lenient mode rejected unsorted keys: %v", c.in, err)
+		}
+		d := NewDecoder([]byte(c.in))
+		d.Strict = true
+		_, err := d.Decode()
+		var de *DecodeError
+		if !errors.Is(err, ErrorUnsortedKeys) || !errors.As(err, &de) || de.Offset != c.offset {
+			t.Errorf("Decode(%s): expected ErrorUnsortedKeys at %d, got %v", c.in, c.offset, err)
+		}
+	}
+
+	d := NewDecoder([]byte("d0:i1e1:a0:1:b0:2:bb0:1:cdee"))
+	d.Strict = true
+	if _, err := d.Decode(); err != nil {
+		t.Errorf("Decode: strict mode rejected sorted keys: %v", err)
+	}
+}
+
+func TestListIterator(t *testing.T) {
+	d := NewDecoder([]byte("li1ei2ei3eei4e"))
+	next, err := d.ListIterator()
+	if err != nil {
+		t.Fatalf("ListIterator: %v", err)
+	}
+	for _, exp := range []int64{1, 2, 3} {
+		o, ok, err := next()
+		if err != nil || !ok || o != exp {
+			t.Fatalf("ListIterator: expected %d, got %v %v (%v)", exp, o, ok, err)
+		}
+	}
+	if o, ok, err := next(); ok || err != nil {
+		t.Fatalf("ListIterator: expected end of list, got %v (%v)", o, err)
+	}
+	if _, ok, err := next(); ok || err != nil {
+		t.Errorf("ListIterator: expected end of list again, got %v", err)
+	}
+	if o, err := d.Decode(); err != nil || o != int64(4) {
+		t.Errorf("Decode: expected 4 after the list, got %v (%v)", o, err)
+	}
+	if !d.Consumed {
+		t.Errorf("Decode: expected consumed decoder")
+	}
+
+	next, err = NewDecoder([]byte("li1e")).ListIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	next()
+	if _, ok, err := next(); ok || !errors.Is(err, ErrorNoTerminator) {
+		t.Errorf("ListIterator: expected ErrorNoTerminator, got %v", err)
+	}
+
+	if _, err = NewDecoder([]byte("i1e")).ListIterator(); err == nil {
+		t.Errorf("ListIterator: expected error for an integer")
+	}
+
+	//the elements end at offset 10, the terminator is the 11th byte
+	d = NewDecoder([]byte("li1ei2ei3ee"))
+	d.MaxTotalLen = 10
+	if next, err = d.ListIterator(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, ok, err := next(); !ok || err != nil {
+			t.Fatalf("ListIterator: element %d rejected (%v)", i, err)
+		}
+	}
+	if _, ok, err := next(); ok || !errors.Is(err, ErrorTotalTooLong) {
+		t.Errorf("ListIterator: expected ErrorTotalTooLong for the terminator, got %v", err)
+	}
+}
+
+func TestEncodeNil(t *testing.T) {
+	for _, c := range []struct {
+		in  interface{}
+		exp string
+	}{
+		{nil, ""},
+		{map[string]interface{}{"a": 1, "b": nil, "c": "x"}, "d1:ai1e1:c1:xe"},
+		{map[string]interface{}{"b": nil}, "de"},
+		{[]interface{}{1, nil, 2}, "li1ei2ee"},
+	} {
+		if b, err := Encode(c.in); err != nil || string(b) != c.exp {
+			t.Errorf("Encode(%#v): expected %q, got %q (%v)", c.in, c.exp, b, err)
+		}
+	}
+}
+
+func TestDecodeChan(t *testing.T) {
+	objs, errs := NewDecoder([]byte("i1e4:spamli2eed1:ai3eei4e")).DecodeChan()
+	var res []interface{}
+	for o := range objs {
+		res = append(res, o)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("DecodeChan: %v", err)
+	}
+	exp := []interface{}{int64(1), "spam", []interface{}{int64(2)}, map[string]interface{}{"a": int64(3)}, int64(4)}
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("DecodeChan: expected %#v, got %#v", exp, res)
+	}
+
+	objs, errs = NewDecoder([]byte("i1ei2x")).DecodeChan()
+	res = nil
+	for o := range objs {
+		res = append(res, o)
+	}
+	if err := <-errs; err == nil || len(res) != 1 {
+		t.Errorf("DecodeChan: expected one object and an error, got %v (%v)", res, err)
+	}
+}
+
+func TestOrderedDict(t *testing.T) {
+	in := "d4:spami1e3:abcd1:zi1e1:a0:e1:bli1ed1:y0:1:x0:eee"
+	d := NewDecoder([]byte(in))
+	d.OrderedDicts = true
+	o, err := d.Decode()
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	od, ok := o.(OrderedDict)
+	if !ok || len(od) != 3 || od[0].Key != "spam" || od[1].Key != "abc" || od[2].Key != "b" {
+		t.Fatalf("Decode: expected OrderedDict in input order, got %#v", o)
+	}
+	b, err := Encode(od)
+	if err != nil || string(b) != in {
+		t.Errorf("Encode: expected %s, got %s (%v)", in, b, err)
+	}
+
+	b, err = Encode(OrderedDict{{"b", 1}, {"skip", nil}, {"a", "x"}})
+	if err != nil || string(b) != "d1:bi1e1:a1:xe" {
+		t.Errorf("Encode: expected d1:bi1e1:a1:xe, got %s (%v)", b, err)
+	}
+	if b, err = Encode(OrderedDict{}); err != nil || string(b) != "de" {
+		t.Errorf("Encode: expected de, got %s (%v)", b, err)
+	}
+}
+
+func TestEncodeScalars(t *testing.T) {
+	for _, in := range []interface{}{
+		int64(0), int64(-1), int64(math.MaxInt64), int64(math.MinInt64), uint8(255),
+		"", "spam", "\x00\xff:e", strings.Repeat("x", 1000),
+	} {
+		var exp string
+		switch v := in.(type) {
+		case string:
+			exp = fmt.Sprintf("%d:%s", len(v), v)
+		default:
+			exp = fmt.Sprintf("i%de", v)
+		}
+		if b, err := Encode(in); err != nil || string(b) != exp {
+			t.Errorf("Encode(%#v): expected %q, got %q (%v)", in, exp, b, err)
+		}
+		var w bytes.Buffer
+		if err := NewWriterEncoder(&w).Encode(in); err != nil || w.String() != exp {
+			t.Errorf("Writer Encode(%#v): expected %q, got %q (%v)", in, exp, w.String(), err)
+		}
+	}
+}
+
+func TestEncodeBytes(t *testing.T) {
+	in := []byte("\x00\x01\x00e:\xff")
+	b, err := Encode(map[string]interface{}{"hash": in, "l": []interface{}{[]byte{}}})
+	if exp := "d4:hash6:\x00\x01\x00e:\xff1:ll0:ee"; err != nil || string(b) != exp {
+		t.Fatalf("Encode: expected %q, got %q (%v)", exp, b, err)
+	}
+	obj, err := NewDecoder(b).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := obj.(map[string]interface{})["hash"].(string); s != string(in) {
+		t.Errorf("Decode: expected %q back, got %q", in, s)
+	}
+	var w bytes.Buffer
+	if err = NewWriterEncoder(&w).Encode(in); err != nil || w.String() != "6:"+string(in) {
+		t.Errorf("Writer Encode: got %q (%v)", w.String(), err)
+	}
+}
+
+func TestEncodeRawBencode(t *testing.T) {
+	//an unsorted info dict is kept as it is
+	info := "d6:pieces0:4:name1:xe"
+	in := map[string]interface{}{
+		"info":     RawBencode(info),
+		"announce": "http://a",
+		"list":     []interface{}{RawBencode("i1e"), int64(2)},
+	}
+	exp := "d8:announce8:http://a4:info" + info + "4:listli1ei2eee"
+	if b, err := Encode(in); err != nil || string(b) != exp {
+		t.Errorf("Encode: expected %q, got %q (%v)", exp, b, err)
+	}
+	var w bytes.Buffer
+	if err := NewWriterEncoder(&w).Encode(in); err != nil || w.String() != exp {
+		t.Errorf("Writer Encode: expected %q, got %q (%v)", exp, w.String(), err)
+	}
+}
+
+func TestEncodeTime(t *testing.T) {
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	b, err := Encode(map[string]interface{}{"creation date": tm})
+	if exp := "d13:creation datei1257894000ee"; err != nil || string(b) != exp {
+		t.Errorf("Encode: expected %q, got %q (%v)", exp, b, err)
+	}
+	if b, err = Encode(tm.In(time.FixedZone("X", 3600))); err != nil || string(b) != "i1257894000e" {
+		t.Errorf("Encode: time zone changed the encoding to %q (%v)", b, err)
+	}
+	if _, err = Encode(struct{}{}); err == nil {
+		t.Errorf("Encode: expected error for struct")
+	}
+}
+
+func TestDecodeMaxTotalLen(t *testing.T) {
+	big := "l" + strings.Repeat("i1e", 10000) + "e"
+	for _, in := range []string{big, "l" + strings.Repeat("4:spam", 10000) + "e", "100000:" + strings.Repeat("x", 100000), big + big} {
+		d := NewDecoder([]byte(in))
+		d.MaxTotalLen = 100
+		_, err := d.DecodeAll()
+		var de *DecodeError
+		if !errors.Is(err, ErrorTotalTooLong) || !errors.As(err, &de) || de.Offset > 100 {
+			t.Errorf("Decode: expected ErrorTotalTooLong within the first 100 bytes, got %v", err)
+		}
+	}
+
+	d := NewDecoder([]byte("i1ei2e4:spam"))
+	d.MaxTotalLen = 12
+	if o, err := d.DecodeAll(); err != nil || len(o) != 3 {
+		t.Errorf("DecodeAll: expected 3 objects within the limit, got %v (%v)", o, err)
+	}
+	d = NewDecoder([]byte("i1ei2e4:spam"))
+	d.MaxTotalLen = 11
+	if o, err := d.DecodeAll(); !errors.Is(err, ErrorTotalTooLong) || len(o) != 2 {
+		t.Errorf("DecodeAll: expected 2 objects and ErrorTotalTooLong, got %v (%v)", o, err)
+	}
+}
+
+func TestDecodeIntegerSign(t *testing.T) {
+	for _, in := range []string{"i1-2e", "i--5e", "i-1-e", "i-e", "i-", "i-0e", "i-00e"} {
+		if o, err := NewDecoder([]byte(in)).Decode(); err == nil {
+			t.Errorf("Decode(%s): expected error, got %v", in, o)
+		}
+	}
+	for _, c := range []struct {
+		in     string
+		offset int
+	}{
+		{"i1-2e", 2},
+		{"i--5e", 2},
+		{"i-1-e", 3},
+	} {
+		_, err := NewDecoder([]byte(c.in)).Decode()
+		var de *DecodeError
+		if !errors.As(err, &de) || de.Kind != DecodeErrorSyntax || de.Offset != c.offset {
+			t.Errorf("Decode(%s): expected syntax error at %d, got %v", c.in, c.offset, err)
+		}
+	}
+	for in, exp := range map[string]int64{"i-5e": -5, "i5e": 5, "i0e": 0, "i-123e": -123} {
+		if o, err := NewDecoder([]byte(in)).Decode(); err != nil || o != exp {
+			t.Errorf("Decode(%s): expected %d, got %v (%v)", in, exp, o, err)
+		}
+	}
+}
+
+func TestDecodeEOF(t *testing.T) {
+	d := NewDecoder([]byte("i1e4:spamli2eed1:ai3ee"))
+	var n int
+	for {
+		_, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		n++
+	}
+	if n != 4 {
+		t.Errorf("Decode: expected 4 objects before io.EOF, got %d", n)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode: expected io.EOF again, got %v", err)
+	}
+
+	for _, in := range []string{"i1eli2e", "i1ed1:a", "i1ed1:ai1e", "i1ei12"} {
+		d := NewDecoder([]byte(in))
+		d.Decode()
+		if _, err := d.Decode(); err == io.EOF || !errors.Is(err, ErrorNoTerminator) {
+			t.Errorf("Decode(%s): expected ErrorNoTerminator, got %v", in, err)
+		}
+	}
+}
+
+func TestDecodeSpan(t *testing.T) {
+	in := []byte("i1ed4:infod6:lengthi5e4:name1:xe1:zl1:aee4:spam")
+	d := NewDecoder(in)
+	var spans [][2]int
+	for {
+		o, start, end, err := d.DecodeSpan()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("DecodeSpan: %v", err)
+		}
+		spans = append(spans, [2]int{start, end})
+		re, err := DecodeSingle(in[start:end])
+		if err != nil || !reflect.DeepEqual(re, o) {
+			t.Errorf("DecodeSpan: span %d-%d decodes to %#v, expected %#v (%v)", start, end, re, o, err)
+		}
+	}
+	if exp := [][2]int{{0, 3}, {3, 41}, {41, 47}}; !reflect.DeepEqual(spans, exp) {
+		t.Errorf("DecodeSpan: expected spans %v, got %v", exp, spans)
+	}
+
+	d = NewDecoder([]byte("i1ei2"))
+	d.DecodeSpan()
+	if _, start, end, err := d.DecodeSpan(); err == nil || start != 3 || end != 3 {
+		t.Errorf("DecodeSpan: expected error at 3, got %d-%d (%v)", start, end, err)
+	}
+}
+
+func TestDecodeSkipWhitespace(t *testing.T) {
+	in := []byte("i1e\ni2e\r\n \t4:spam\n")
+	exp := []interface{}{int64(1), int64(2), "spam"}
+
+	d := NewDecoder(in)
+	d.SkipWhitespace = true
+	if o, err := d.DecodeAll(); err != nil || !reflect.DeepEqual(o, exp) {
+		t.Errorf("DecodeAll: expected %v, got %v (%v)", exp, o, err)
+	}
+	d = NewDecoder(in)
+	d.SkipWhitespace = true
+	for _, e := range exp {
+		if o, err := d.Decode(); err != nil || o != e {
+			t.Errorf("Decode: expected %v, got %v (%v)", e, o, err)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode: expected io.EOF, got %v", err)
+	}
+	d = NewDecoder([]byte(" 1: \n"))
+	d.SkipWhitespace = true
+	if o, start, end, err := d.DecodeSpan(); err != nil || o != " " || start != 1 || end != 4 {
+		t.Errorf("DecodeSpan: expected \" \" at 1-4, got %q at %d-%d (%v)", o, start, end, err)
+	}
+
+	if _, err := NewDecoder([]byte("i1e\ni2e")).DecodeAll(); err == nil {
+		t.Errorf("DecodeAll: expected error for whitespace in strict mode")
+	}
+	for _, in := range []string{"i 1e", "l i1ee", "d1:a i1ee", "4 :spam"} {
+		d := NewDecoder([]byte(in))
+		d.SkipWhitespace = true
+		if _, err := d.DecodeAll(); err == nil {
+			t.Errorf("DecodeAll(%q): expected error for whitespace inside an object", in)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bencode
+
+//IsCanonical reports whether data holds exactly one object in canonical
+//bencode form: dict keys in strictly increasing order, and string lengths
+//without leading zeros. Data that isn't valid bencode at all yields an
+//error, including integers with leading zeros or negative zero.
+func IsCanonical(data []byte) (bool, error) {
+	if _, err := DecodeSingle(data); err != nil {
+		return false, err
+	}
+	end, ok := canonicalAt(data, 0)
+	return ok && end == len(data), nil
+}
+
+//check the canonical form of the object at pos in the already validated
+//stream b. returns the position after the object.
+func canonicalAt(b []byte, pos int) (end int, ok bool) {
+	switch c := b[pos]; {
+	case c == 'i':
+		//leading zeros and negative zero don't get past the decoder
+		pos++
+		for b[pos] != 'e' {
+			pos++
+		}
+		return pos + 1, true
+	case c == 'l':
+		pos++
+		for b[pos] != 'e' {
+			if pos, ok = canonicalAt(b, pos); !ok {
+				return 0, false
+			}
+		}
+		return pos + 1, true
+	case c == 'd':
+		pos++
+		var prev string
+		for first := true; b[pos] != 'e'; first = false {
+			kstart := pos
+			if pos, ok = canonicalAt(b, pos); !ok {
+				return 0, false
+			}
+			key := stringAt(b, kstart)
+			if !first && key <= prev {
+				return 0, false
+			}
+			prev = key
+			if pos, ok = canonicalAt(b, pos); !ok {
+				return 0, false
+			}
+		}
+		return pos + 1, true
+	default:
+		start := pos
+		n := 0
+		for b[pos] != ':' {
+			n = n*10 + int(b[pos]-'0')
+			pos++
+		}
+		if b[start] == '0' && pos-start > 1 {
+			return 0, false
+		}
+		return pos + 1 + n, true
+	}
+}
+
+//the contents of the already validated string token at pos
+func stringAt(b []byte, pos int) string {
+	n := 0
+	for b[pos] != ':' {
+		n = n*10 + int(b[pos]-'0')
+		pos++
+	}
+	return string(b[pos+1 : pos+1+n])
+}
+
+//Canonicalize decodes the single object in data and encodes it again in
+//canonical form. Dicts with duplicate keys keep the last value.
+func Canonicalize(data []byte) ([]byte, error) {
+	o, err := DecodeSingle(data)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(o)
+}
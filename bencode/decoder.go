@@ -8,7 +8,10 @@ package bencode
 import (
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"strconv"
+	"unsafe"
 )
 
 //A Decoder reads and decodes bencoded objects from an input stream.
@@ -23,26 +26,270 @@ import (
 type Decoder struct {
 	stream   []byte
 	pos      int
+	depth    int  //nesting level of the list or dict being decoded
 	Consumed bool //true if we have consumed all tokens
+
+	//maximum nesting of lists and dicts. decoding fails with
+	//ErrorMaxDepth beyond it. 0 means DefaultMaxDepth.
+	MaxDepth int
+
+	//maximum length of a string. decoding fails with ErrorStringTooLong
+	//beyond it. 0 means strings are only limited by the input length.
+	MaxStringLen int
+
+	//maximum number of input bytes to consume. decoding fails with
+	//ErrorTotalTooLong once an object reaches beyond it. 0 means no limit.
+	MaxTotalLen int
+
+	//if true, integers that don't fit in an int64 are returned as
+	//*big.Int instead of failing with DecodeErrorInteger.
+	BigInts bool
+
+	//if true, dicts with a repeated key are rejected with
+	//ErrorDuplicateKey instead of keeping the last value, and dicts whose
+	//keys aren't sorted with ErrorUnsortedKeys.
+	Strict bool
+
+	//if true, dicts with a repeated key are rejected with
+	//ErrorDuplicateKey like in Strict mode, but unsorted keys are
+	//accepted. Torrents are often not sorted, but a repeated key makes
+	//them ambiguous.
+	NoDuplicateKeys bool
+
+	//if true, dicts are decoded as OrderedDict instead of
+	//map[string]interface{}, keeping the order of their keys.
+	OrderedDicts bool
+
+	//if true, whitespace (space, \t, \r and \n) between top-level objects
+	//is skipped instead of being a syntax error.
+	SkipWhitespace bool
+
+	//if true, strings are returned as views into the input instead of
+	//copies, saving an allocation per string. WARNING: the input must not
+	//be modified while any decoded string is in use, the strings would
+	//change with it.
+	ZeroCopyStrings bool
+
+	//if not nil, strings of up to 256 bytes are looked up in Intern and
+	//the stored copy is returned, new strings are added. Dict keys and
+	//other recurring strings then share memory across the documents
+	//decoded with the same map. Longer strings, like pieces, are never
+	//interned. The map isn't safe for concurrent use by several decoders,
+	//which is why Clone doesn't share it.
+	Intern map[string]string
 }
 
+//strings longer than this aren't interned
+const internMaxLen = 256
+
+//An OrderedDict is a dict that keeps its entries in the order they were
+//decoded in (see Decoder.OrderedDicts) or are to be encoded in. Encoding
+//an OrderedDict doesn't sort its keys, so a non-canonical dict can be
+//reproduced byte for byte.
+type OrderedDict []DictEntry
+
+//DictEntry is an entry of an OrderedDict.
+type DictEntry struct {
+	Key   string
+	Value interface{}
+}
+
+//DefaultMaxDepth is the nesting limit used when Decoder.MaxDepth is 0
+const DefaultMaxDepth = 100
+
 //NewDecoder creates a new decoder for the given token stream
-func NewDecoder(b []byte) *Decoder { return &Decoder{b, 0, false} }
+func NewDecoder(b []byte) *Decoder { return &Decoder{stream: b, Consumed: len(b) == 0} }
+
+//Reset makes the decoder read from b as if it was newly created,
+//so that a Decoder can be reused (e.g. through a sync.Pool).
+func (self *Decoder) Reset(b []byte) {
+	self.stream = b
+	self.pos = 0
+	self.depth = 0
+	self.Consumed = len(b) == 0
+}
+
+//Clone returns a new decoder positioned at the same offset in the same
+//stream and with the same settings. Decoding from the clone doesn't
+//affect self, so it can be used to look ahead.
+//
+//A Decoder mustn't be used from several goroutines at once, but separate
+//decoders (including clones) may be used concurrently. For that a clone
+//gets its own empty Intern map if self has one.
+func (self *Decoder) Clone() *Decoder {
+	c := *self
+	if c.Intern != nil {
+		c.Intern = make(map[string]string)
+	}
+	return &c
+}
 
-//Decode reads one object from the input stream
+//Kind is the type of a bencoded object
+type Kind int
+
+const (
+	KindInt Kind = iota
+	KindString
+	KindList
+	KindDict
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindInt:
+		return "int"
+	case KindString:
+		return "string"
+	case KindList:
+		return "list"
+	case KindDict:
+		return "dict"
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+//PeekType returns the kind of the next object without consuming it.
+//It only looks at the first byte of the object, so the object itself
+//may still be malformed.
+func (self *Decoder) PeekType() (Kind, error) {
+	if self.Consumed || self.pos >= len(self.stream) {
+		return 0, self.wrap(self.pos, DecodeErrorConsumed, ErrorConsumed)
+	}
+	switch c := self.stream[self.pos]; {
+	case c == 'i':
+		return KindInt, nil
+	case c == 'l':
+		return KindList, nil
+	case c == 'd':
+		return KindDict, nil
+	case c >= '0' && c <= '9':
+		return KindString, nil
+	}
+	return 0, self.error(self.pos, DecodeErrorSyntax, fmt.Sprintf("Invalid byte '%s'", string(self.stream[self.pos])))
+}
+
+//Decode is a wrapper for Decoder.Decode, mirroring Encode.
+//It returns the first object in data. Anything after that object is
+//ignored; use DecodeSingle to reject trailing data.
+func Decode(data []byte) (interface{}, error) {
+	return NewDecoder(data).Decode()
+}
+
+//Decode reads one object from the input stream. Once the stream is
+//consumed at an object boundary it returns io.EOF, so that a stream of
+//objects can be read with
+//	for {
+//		o, err := d.Decode()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//A stream ending within an object yields an error matching
+//ErrorNoTerminator or a DecodeError of kind length instead.
 func (self *Decoder) Decode() (res interface{}, err error) {
-	return self.nextObject()
+	self.skipWhitespace()
+	if self.Consumed || self.pos >= len(self.stream) {
+		self.Consumed = true
+		return nil, io.EOF
+	}
+	if res, err = self.nextObject(); err == nil {
+		self.skipWhitespace()
+	}
+	return
+}
+
+//skip whitespace between top-level objects if SkipWhitespace is set
+func (self *Decoder) skipWhitespace() {
+	if !self.SkipWhitespace {
+		return
+	}
+	for ; self.pos < len(self.stream); self.pos++ {
+		switch self.stream[self.pos] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return
+	}
+	self.Consumed = true
+}
+
+//DecodeSpan is like Decode but also returns the offsets of the first byte
+//of the object and the byte after it in the input, so that the object's
+//original encoding can be recovered.
+func (self *Decoder) DecodeSpan() (res interface{}, start, end int, err error) {
+	self.skipWhitespace()
+	start = self.pos
+	if self.Consumed || self.pos >= len(self.stream) {
+		self.Consumed = true
+		return nil, start, start, io.EOF
+	}
+	if res, err = self.nextObject(); err != nil {
+		return nil, start, start, err
+	}
+	end = self.pos
+	self.skipWhitespace()
+	return res, start, end, nil
 }
 
 var (
-	ErrorConsumed     = errors.New("This parser's token stream is consumed!")
-	ErrorNoTerminator = errors.New("No terminating 'e' found!")
+	ErrorConsumed      = errors.New("This parser's token stream is consumed!")
+	ErrorNoTerminator  = errors.New("No terminating 'e' found!")
+	ErrorMaxDepth      = errors.New("Maximum nesting depth exceeded!")
+	ErrorStringTooLong = errors.New("Maximum string length exceeded!")
+	ErrorTotalTooLong  = errors.New("Maximum total length exceeded!")
+	ErrorDuplicateKey  = errors.New("Duplicate key in dict!")
+	ErrorUnsortedKeys  = errors.New("Dict keys are not sorted!")
 )
 
-//DecodeAll reads all objects from the input stream
+//kinds of DecodeError
+const (
+	DecodeErrorSyntax        = "syntax"          //unexpected byte
+	DecodeErrorNoTerminator  = "no terminator"   //input ends inside a list, dict or integer
+	DecodeErrorLeadingZero   = "leading zero"    //integer with leading zeros
+	DecodeErrorInteger       = "integer"         //empty or out of range integer
+	DecodeErrorLength        = "length"          //invalid string length
+	DecodeErrorConsumed      = "consumed"        //no more objects in the input
+	DecodeErrorMaxDepth      = "max depth"       //see Decoder.MaxDepth
+	DecodeErrorStringTooLong = "string too long" //see Decoder.MaxStringLen
+	DecodeErrorTotalTooLong  = "total too long"  //see Decoder.MaxTotalLen
+	DecodeErrorDuplicateKey  = "duplicate key"   //see Decoder.Strict and NoDuplicateKeys
+	DecodeErrorUnsortedKeys  = "unsorted keys"   //see Decoder.Strict
+)
+
+//DecodeError is the error returned when decoding fails.
+//Errors of the kinds consumed, no terminator, max depth, string too long,
+//total too long, duplicate key and unsorted keys match ErrorConsumed,
+//ErrorNoTerminator, ErrorMaxDepth, ErrorStringTooLong, ErrorTotalTooLong,
+//ErrorDuplicateKey and ErrorUnsortedKeys respectively with errors.Is.
+type DecodeError struct {
+	Offset int    //position in the input where decoding failed
+	Kind   string //one of the DecodeError* constants
+	Msg    string
+
+	err error //sentinel error matched by errors.Is
+}
+
+func (e *DecodeError) Error() string { return fmt.Sprintf("%s (at offset %d)", e.Msg, e.Offset) }
+
+func (e *DecodeError) Unwrap() error { return e.err }
+
+//create a DecodeError
+func (self *Decoder) error(offset int, kind, msg string) error {
+	return &DecodeError{Offset: offset, Kind: kind, Msg: msg}
+}
+
+//create a DecodeError matching the sentinel error err
+func (self *Decoder) wrap(offset int, kind string, err error) error {
+	return &DecodeError{Offset: offset, Kind: kind, Msg: err.Error(), err: err}
+}
+
+//DecodeAll reads all remaining objects from the input stream.
+//An empty stream yields an empty slice.
 func (self *Decoder) DecodeAll() (res []interface{}, err error) {
+	res = []interface{}{}
 	var obj interface{}
-	for err = ErrorConsumed; !self.Consumed; err = nil {
+	for self.skipWhitespace(); !self.Consumed; self.skipWhitespace() {
 		if obj, err = self.nextObject(); err != nil {
 			return
 		}
@@ -51,69 +298,239 @@ func (self *Decoder) DecodeAll() (res []interface{}, err error) {
 	return
 }
 
+//DecodeChan decodes the remaining objects in a new goroutine and sends
+//them in order on the first channel, which is closed when the stream is
+//consumed or decoding fails. A decoding error is sent on the second
+//channel, which is closed after the first one. The objects must be
+//received until the channel is closed, otherwise the goroutine leaks;
+//the decoder must not be used otherwise until then.
+func (self *Decoder) DecodeChan() (<-chan interface{}, <-chan error) {
+	objs := make(chan interface{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(objs)
+		for self.skipWhitespace(); !self.Consumed; self.skipWhitespace() {
+			obj, err := self.nextObject()
+			if err != nil {
+				errs <- err
+				return
+			}
+			objs <- obj
+		}
+	}()
+	return objs, errs
+}
+
+//ListIterator starts reading the list at the current position and
+//returns a function yielding its elements one per call, so that the list
+//is never held in memory as a whole. The function returns false once the
+//list's terminating 'e' was read, after which the decoder is positioned
+//behind the list. The decoder must not be used otherwise until then.
+//
+//	next, err := d.ListIterator()
+//	o, ok, err := next()
+//	for ; ok; o, ok, err = next() {
+//		//use o
+//	}
+//	//err is nil if the whole list was read
+func (self *Decoder) ListIterator() (func() (interface{}, bool, error), error) {
+	kind, err := self.PeekType()
+	if err != nil {
+		return nil, err
+	}
+	if kind != KindList {
+		return nil, self.error(self.pos, DecodeErrorSyntax, "This is not a list!")
+	}
+	if self.beyondLimit(self.pos + 1) {
+		return nil, self.wrap(self.pos, DecodeErrorTotalTooLong, ErrorTotalTooLong)
+	}
+	if err = self.enter(); err != nil {
+		return nil, err
+	}
+	start := self.pos
+	self.pos++ //skip 'l'
+
+	var done bool
+	var last error
+	next := func() (interface{}, bool, error) {
+		if done {
+			return nil, false, last
+		}
+		if self.pos >= len(self.stream) {
+			done, last = true, self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+			self.depth--
+			return nil, false, last
+		}
+		if self.stream[self.pos] == 'e' {
+			self.pos++ //skip 'e'
+			done = true
+			self.depth--
+			if self.beyondLimit(self.pos) {
+				last = self.wrap(start, DecodeErrorTotalTooLong, ErrorTotalTooLong)
+				return nil, false, last
+			}
+			if self.pos >= len(self.stream) {
+				self.Consumed = true
+			}
+			return nil, false, nil
+		}
+		o, err := self.nextObject()
+		if err != nil {
+			done, last = true, err
+			self.depth--
+			return nil, false, err
+		}
+		return o, true, nil
+	}
+	return next, nil
+}
+
+//DecodeSingle decodes data which must hold exactly one object.
+//Anything but whitespace after that object is an error.
+func DecodeSingle(data []byte) (interface{}, error) {
+	self := NewDecoder(data)
+	res, err := self.nextObject()
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range self.stream[self.pos:] {
+		switch c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return nil, self.error(self.pos, DecodeErrorSyntax, "Trailing data after object")
+		}
+	}
+	return res, nil
+}
+
+//enter a list or dict, checking the nesting limit
+func (self *Decoder) enter() error {
+	max := self.MaxDepth
+	if max <= 0 {
+		max = DefaultMaxDepth
+	}
+	if self.depth >= max {
+		return self.wrap(self.pos, DecodeErrorMaxDepth, ErrorMaxDepth)
+	}
+	self.depth++
+	return nil
+}
+
+//DecodeTyped decodes the single object in data (see DecodeSingle) and
+//returns it as a T. It fails if the object is of another type, e.g.
+//	d, err := bencode.DecodeTyped[map[string]interface{}](b)
+func DecodeTyped[T any](data []byte) (T, error) {
+	var zero T
+	o, err := DecodeSingle(data)
+	if err != nil {
+		return zero, err
+	}
+	res, ok := o.(T)
+	if !ok {
+		return zero, fmt.Errorf("Decoded %T, expected %T", o, zero)
+	}
+	return res, nil
+}
+
 //fetch the next object at position 'pos' in 'stream'
 func (self *Decoder) nextObject() (res interface{}, err error) {
-	if self.Consumed {
-		return nil, ErrorConsumed
+	if self.Consumed || self.pos >= len(self.stream) {
+		self.Consumed = true
+		return nil, self.wrap(self.pos, DecodeErrorConsumed, ErrorConsumed)
+	}
+
+	if self.beyondLimit(self.pos + 1) {
+		return nil, self.wrap(self.pos, DecodeErrorTotalTooLong, ErrorTotalTooLong)
 	}
 
+	start := self.pos
 	switch c := self.stream[self.pos]; c {
 	case 'i':
 		res, err = self.nextInteger()
 	case 'l':
 		res, err = self.nextList()
 	case 'd':
-		res, err = self.nextDict()
+		if self.OrderedDicts {
+			res, err = self.nextOrderedDict()
+		} else {
+			res, err = self.nextDict()
+		}
 	default:
 		if c >= '0' && c <= '9' {
 			res, err = self.nextString()
 		} else {
-			err = fmt.Errorf("Couldn't parse '%s' index %d (%s)", self.stream, self.pos, string(self.stream[self.pos]))
+			err = self.error(self.pos, DecodeErrorSyntax, fmt.Sprintf("Invalid byte '%s'", string(c)))
 		}
 	}
+	if err == nil && self.beyondLimit(self.pos) {
+		return nil, self.wrap(start, DecodeErrorTotalTooLong, ErrorTotalTooLong)
+	}
 	if self.pos >= len(self.stream) {
 		self.Consumed = true
 	}
 	return
 }
 
+//true if the input up to end exceeds MaxTotalLen
+func (self *Decoder) beyondLimit(end int) bool {
+	return self.MaxTotalLen > 0 && end > self.MaxTotalLen
+}
+
 //fetches next integer from stream and advances pos pointer
-func (self *Decoder) nextInteger() (res int64, err error) {
+func (self *Decoder) nextInteger() (res interface{}, err error) {
 	if self.stream[self.pos] != 'i' {
-		return 0, errors.New("No starting 'i' found")
+		return nil, self.error(self.pos, DecodeErrorSyntax, "No starting 'i' found")
 	}
 	self.pos++
 	idx := self.pos
 
-	if self.stream[idx] == '-' {
+	if idx < len(self.stream) && self.stream[idx] == '-' {
 		idx++
 	}
 	start := idx
+	if idx >= len(self.stream) {
+		return nil, self.wrap(idx, DecodeErrorNoTerminator, ErrorNoTerminator)
+	}
 
 	for self.stream[idx] != 'e' {
-		//check for bytes != '-' and '0'..'9'
+		//a sign is only allowed directly after the 'i'
+		if self.stream[idx] == '-' {
+			err = self.error(idx, DecodeErrorSyntax, "Misplaced '-' in encoded integer.")
+			return
+		}
 		if self.stream[idx] < '0' || self.stream[idx] > '9' {
-			err = fmt.Errorf("Invalid byte '%s' in encoded integer.", string(self.stream[idx]))
+			err = self.error(idx, DecodeErrorSyntax, fmt.Sprintf("Invalid byte '%s' in encoded integer.", string(self.stream[idx])))
 			return
 		}
 
 		if idx++; idx >= len(self.stream) {
-			return 0, ErrorNoTerminator
+			return nil, self.wrap(idx, DecodeErrorNoTerminator, ErrorNoTerminator)
 		}
 	}
 
 	if start == idx {
-		err = errors.New("No bytes in integer")
+		err = self.error(idx, DecodeErrorInteger, "No bytes in integer")
 		return
 	}
 	if self.stream[start] == '0' && idx-start > 1 {
-		err = errors.New("Leading Zeros are not allowed in bencoded integers!")
+		err = self.error(start, DecodeErrorLeadingZero, "Leading Zeros are not allowed in bencoded integers!")
+		return
+	}
+	if self.stream[start] == '0' && start > self.pos {
+		err = self.error(self.pos, DecodeErrorInteger, "Negative zero is not allowed in bencoded integers!")
 		return
 	}
 
 	s := string(self.stream[self.pos:idx])
-	if res, err = strconv.ParseInt(s, 10, 64); err != nil {
-		return // Or: return 0, err
+	i, e := strconv.ParseInt(s, 10, 64)
+	if e != nil {
+		if !self.BigInts || !errors.Is(e, strconv.ErrRange) {
+			return nil, self.error(self.pos, DecodeErrorInteger, e.Error())
+		}
+		res, _ = new(big.Int).SetString(s, 10)
+	} else {
+		res = i
 	}
 	self.pos = idx + 1
 
@@ -123,7 +540,7 @@ func (self *Decoder) nextInteger() (res int64, err error) {
 //fetches next string from stream and advances pos pointer
 func (self *Decoder) nextString() (res string, err error) {
 	if self.stream[self.pos] < '0' || self.stream[self.pos] > '9' {
-		err = errors.New("No string length determinator found")
+		err = self.error(self.pos, DecodeErrorSyntax, "No string length determinator found")
 		return
 	}
 
@@ -132,31 +549,59 @@ func (self *Decoder) nextString() (res string, err error) {
 	len_end := self.pos
 	for self.stream[len_end] != ':' {
 		if len_end++; len_end >= len(self.stream) {
-			err = errors.New("No string found ...")
+			err = self.error(len_end, DecodeErrorLength, "No string found ...")
 			return
 		}
 	}
 	len_str := string(self.stream[len_start:len_end])
 
 	if l, e := strconv.Atoi(len_str); e != nil {
-		err = fmt.Errorf("Couldn't parse string length specifier: %s", e.Error())
+		err = self.error(len_start, DecodeErrorLength, "Couldn't parse string length specifier: "+e.Error())
+	} else if self.MaxStringLen > 0 && l > self.MaxStringLen {
+		err = self.wrap(len_start, DecodeErrorStringTooLong, ErrorStringTooLong)
+	} else if self.MaxTotalLen > 0 && l > self.MaxTotalLen-len_end-1 {
+		err = self.wrap(len_start, DecodeErrorTotalTooLong, ErrorTotalTooLong)
 	} else if l >= len(self.stream[len_end:]) {
-		err = errors.New("Specified length longer than data buffer ...")
+		err = self.error(len_start, DecodeErrorLength, "Specified length longer than data buffer ...")
 	} else {
 		len_end++ //skip the ':'
-		res = string(self.stream[len_end : len_end+l])
+		if self.Intern != nil && l <= internMaxLen {
+			res = self.intern(self.stream[len_end : len_end+l])
+		} else if self.ZeroCopyStrings {
+			res = unsafe.String(unsafe.SliceData(self.stream[len_end:]), l)
+		} else {
+			res = string(self.stream[len_end : len_end+l])
+		}
 		self.pos = len_end + l
 	}
 	return
 }
 
+//the copy of b in self.Intern, added if it's not there yet
+func (self *Decoder) intern(b []byte) string {
+	if s, ok := self.Intern[string(b)]; ok { //doesn't allocate
+		return s
+	}
+	s := string(b)
+	self.Intern[s] = s
+	return s
+}
+
 //fetches a list (and its contents) from stream and advances pos
 func (self *Decoder) nextList() (res []interface{}, err error) {
 	if self.stream[self.pos] != 'l' {
-		err = errors.New("This is not a list!")
+		err = self.error(self.pos, DecodeErrorSyntax, "This is not a list!")
 		return
 	}
+	if err = self.enter(); err != nil {
+		return
+	}
+	defer func() { self.depth-- }()
 	self.pos++ //skip 'l'
+	if self.pos >= len(self.stream) {
+		err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+		return
+	}
 
 	if self.stream[self.pos] == 'e' {
 		self.pos++ //skip 'e'
@@ -170,7 +615,7 @@ func (self *Decoder) nextList() (res []interface{}, err error) {
 		}
 		res = append(res, obj)
 		if self.pos >= len(self.stream) {
-			err = ErrorNoTerminator
+			err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
 			return
 		}
 		if self.stream[self.pos] == 'e' {
@@ -182,16 +627,38 @@ func (self *Decoder) nextList() (res []interface{}, err error) {
 }
 
 //fetches a dict
-//bencoded dicts must have their keys sorted lexically. but I guess
-//we can ignore that and work with unsorted maps. (wtf?! sorted maps ...)
+//bencoded dicts must have their keys sorted lexically. that is only
+//checked in strict mode, otherwise we work with unsorted maps.
 func (self *Decoder) nextDict() (res map[string]interface{}, err error) {
+	res = make(map[string]interface{})
+	err = self.dictEntries(func(key string, val interface{}) { res[key] = val })
+	return
+}
+
+//fetches a dict as an OrderedDict
+func (self *Decoder) nextOrderedDict() (res OrderedDict, err error) {
+	res = OrderedDict{}
+	err = self.dictEntries(func(key string, val interface{}) {
+		res = append(res, DictEntry{key, val})
+	})
+	return
+}
+
+//reads a dict, passing its entries in input order to add
+func (self *Decoder) dictEntries(add func(key string, val interface{})) (err error) {
 	if self.stream[self.pos] != 'd' {
-		err = errors.New("This is not a dict!")
+		err = self.error(self.pos, DecodeErrorSyntax, "This is not a dict!")
+		return
+	}
+	if err = self.enter(); err != nil {
 		return
 	}
+	defer func() { self.depth-- }()
 	self.pos++ //skip 'd'
-
-	res = make(map[string]interface{})
+	if self.pos >= len(self.stream) {
+		err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+		return
+	}
 
 	if self.stream[self.pos] == 'e' {
 		self.pos++ //skip 'e'
@@ -199,20 +666,42 @@ func (self *Decoder) nextDict() (res map[string]interface{}, err error) {
 	}
 
 	var (
-		key string
-		val interface{}
+		key, prev string
+		val       interface{}
+		seen      map[string]bool //keys so far, to find duplicates
 	)
+	if self.Strict || self.NoDuplicateKeys {
+		seen = make(map[string]bool)
+	}
 	for {
+		keyPos := self.pos
 		if key, err = self.nextString(); err != nil {
 			return
 		}
+		if self.pos >= len(self.stream) {
+			err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+			return
+		}
+		if seen != nil {
+			if seen[key] {
+				err = self.wrap(keyPos, DecodeErrorDuplicateKey, ErrorDuplicateKey)
+				return
+			}
+			seen[key] = true
+		}
+		if self.Strict {
+			if key < prev {
+				err = self.wrap(keyPos, DecodeErrorUnsortedKeys, ErrorUnsortedKeys)
+				return
+			}
+			prev = key
+		}
 		if val, err = self.nextObject(); err != nil {
 			return
 		}
-		//fmt.Printf("key: %s\nval: %#v\n", key, val)
-		res[string(key)] = val
+		add(key, val)
 		if self.pos >= len(self.stream) {
-			err = ErrorNoTerminator
+			err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
 			return
 		}
 		if self.stream[self.pos] == 'e' {
@@ -222,3 +711,56 @@ func (self *Decoder) nextDict() (res map[string]interface{}, err error) {
 	}
 	return
 }
+
+//RawDictValue returns the bytes of the value stored under key in the
+//bencoded dict at the start of b, exactly as they appear in b.
+//The returned slice shares its backing array with b.
+//A key that appears more than once is ambiguous and fails with
+//ErrorDuplicateKey.
+func RawDictValue(b []byte, key string) (raw []byte, err error) {
+	self := NewDecoder(b)
+	if len(b) == 0 || b[0] != 'd' {
+		err = self.error(0, DecodeErrorSyntax, "This is not a dict!")
+		return
+	}
+	self.pos++ //skip 'd'
+	if self.pos >= len(self.stream) {
+		err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+		return
+	}
+
+	var (
+		k     string
+		found []byte
+	)
+	for self.pos < len(self.stream) && self.stream[self.pos] != 'e' {
+		keyPos := self.pos
+		if k, err = self.nextString(); err != nil {
+			return
+		}
+		if k == key && found != nil {
+			err = self.wrap(keyPos, DecodeErrorDuplicateKey, ErrorDuplicateKey)
+			return
+		}
+		if self.pos >= len(self.stream) {
+			err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+			return
+		}
+		start := self.pos
+		if _, err = self.nextObject(); err != nil {
+			return
+		}
+		if k == key {
+			found = self.stream[start:self.pos]
+		}
+	}
+	if self.pos >= len(self.stream) {
+		err = self.wrap(self.pos, DecodeErrorNoTerminator, ErrorNoTerminator)
+		return
+	}
+	if found != nil {
+		return found, nil
+	}
+	err = fmt.Errorf("Key '%s' not found in dict", key)
+	return
+}
@@ -0,0 +1,143 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+//kinds of DiffEntry
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+//A DiffEntry is a single difference found by Diff. Path is the
+//slash-separated location of the value (e.g. "info/piece length"; list
+//elements are addressed by their index). Old and New describe the value
+//in a and b; Old is empty for DiffAdded and New for DiffRemoved.
+type DiffEntry struct {
+	Path string
+	Kind string
+	Old  string
+	New  string
+}
+
+func (e DiffEntry) String() string {
+	switch e.Kind {
+	case DiffAdded:
+		return fmt.Sprintf("+ %s: %s", e.Path, e.New)
+	case DiffRemoved:
+		return fmt.Sprintf("- %s: %s", e.Path, e.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", e.Path, e.Old, e.New)
+}
+
+//Diff decodes the single objects in a and b and returns their
+//differences, ordered by path. Dicts are compared key by key and lists
+//element by element; binary strings are described by their length and
+//SHA-1 hash instead of their contents.
+func Diff(a, b []byte) ([]DiffEntry, error) {
+	oa, err := DecodeSingle(a)
+	if err != nil {
+		return nil, err
+	}
+	ob, err := DecodeSingle(b)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []DiffEntry
+	diffObject(&diffs, "", oa, ob)
+	return diffs, nil
+}
+
+func diffObject(diffs *[]DiffEntry, path string, a, b interface{}) {
+	switch va := a.(type) {
+	case map[string]interface{}:
+		if vb, ok := b.(map[string]interface{}); ok {
+			diffDict(diffs, path, va, vb)
+			return
+		}
+	case []interface{}:
+		if vb, ok := b.([]interface{}); ok {
+			diffList(diffs, path, va, vb)
+			return
+		}
+	case string:
+		if vb, ok := b.(string); ok && va == vb {
+			return
+		}
+	case int64:
+		if vb, ok := b.(int64); ok && va == vb {
+			return
+		}
+	}
+	*diffs = append(*diffs, DiffEntry{path, DiffChanged, diffValue(a), diffValue(b)})
+}
+
+func diffDict(diffs *[]DiffEntry, path string, a, b map[string]interface{}) {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		va, ina := a[k]
+		vb, inb := b[k]
+		p := diffPath(path, k)
+		switch {
+		case !inb:
+			*diffs = append(*diffs, DiffEntry{p, DiffRemoved, diffValue(va), ""})
+		case !ina:
+			*diffs = append(*diffs, DiffEntry{p, DiffAdded, "", diffValue(vb)})
+		default:
+			diffObject(diffs, p, va, vb)
+		}
+	}
+}
+
+func diffList(diffs *[]DiffEntry, path string, a, b []interface{}) {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		p := diffPath(path, strconv.Itoa(i))
+		switch {
+		case i >= len(b):
+			*diffs = append(*diffs, DiffEntry{p, DiffRemoved, diffValue(a[i]), ""})
+		case i >= len(a):
+			*diffs = append(*diffs, DiffEntry{p, DiffAdded, "", diffValue(b[i])})
+		default:
+			diffObject(diffs, p, a[i], b[i])
+		}
+	}
+}
+
+func diffPath(path, elem string) string {
+	if path == "" {
+		return elem
+	}
+	return path + "/" + elem
+}
+
+//short description of a value for a DiffEntry
+func diffValue(o interface{}) string {
+	switch v := o.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		if isText(v) {
+			return strconv.Quote(v)
+		}
+		return fmt.Sprintf("<%d bytes, sha1 %x>", len(v), sha1.Sum([]byte(v)))
+	case []interface{}:
+		return fmt.Sprintf("<list of %d>", len(v))
+	case map[string]interface{}:
+		return fmt.Sprintf("<dict of %d>", len(v))
+	}
+	return fmt.Sprintf("%#v", o)
+}
@@ -0,0 +1,101 @@
+package bencode
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//number of leading bytes of a binary string shown by Dump
+const dumpHexPrefix = 8
+
+//Dump decodes data and writes an indented, JSON-like view of its objects
+//to w. Binary strings (such as the pieces of a torrent) are shown as their
+//length and a hex prefix instead of their raw bytes.
+func Dump(data []byte, w io.Writer) error {
+	objs, err := NewDecoder(data).DecodeAll()
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, o := range objs {
+		dumpObject(bw, o, 0)
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
+
+func dumpObject(w *bufio.Writer, o interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := o.(type) {
+	case int64:
+		fmt.Fprintf(w, "%d", v)
+	case string:
+		w.WriteString(dumpString(v))
+	case []interface{}:
+		if len(v) == 0 {
+			w.WriteString("[]")
+			return
+		}
+		w.WriteString("[\n")
+		for i, e := range v {
+			w.WriteString(indent + "  ")
+			dumpObject(w, e, depth+1)
+			if i < len(v)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(indent + "]")
+	case map[string]interface{}:
+		if len(v) == 0 {
+			w.WriteString("{}")
+			return
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		w.WriteString("{\n")
+		for i, k := range keys {
+			w.WriteString(indent + "  " + dumpString(k) + ": ")
+			dumpObject(w, v[k], depth+1)
+			if i < len(keys)-1 {
+				w.WriteString(",")
+			}
+			w.WriteString("\n")
+		}
+		w.WriteString(indent + "}")
+	default:
+		fmt.Fprintf(w, "%#v", v)
+	}
+}
+
+//quote text, abbreviate binary data
+func dumpString(s string) string {
+	if isText(s) {
+		return fmt.Sprintf("%q", s)
+	}
+	if len(s) <= dumpHexPrefix {
+		return fmt.Sprintf("<%d bytes: %x>", len(s), s)
+	}
+	return fmt.Sprintf("<%d bytes: %x...>", len(s), s[:dumpHexPrefix])
+}
+
+//true if s is printable utf-8 text
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
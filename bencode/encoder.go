@@ -2,99 +2,231 @@ package bencode
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"reflect"
 	"sort"
+	"strconv"
+	"time"
 )
 
 //Encoder takes care of encoding objects into byte streams.
-//The result of the encoding operation is available in Encoder.Bytes.
+//The result of the encoding operation is available in Encoder.Bytes,
+//unless the Encoder was created with NewWriterEncoder.
 //Consecutive operations are appended to the byte stream.
 //
-//Accepts only string, int/int64, []interface{} and map[string]interface{} as input.
+//Accepts only string, []byte (encoded as a string), the integer types,
+//[]interface{}, map[string]interface{}, OrderedDict and RawBencode as
+//input. A time.Time is encoded as the integer Unix time in seconds, the
+//format of a torrent's creation date. A nil value is skipped: it produces
+//no output, and a dict key with a nil value is left out, which is handy
+//when building dicts with optional keys.
 type Encoder struct {
 	Bytes []byte		//the result byte stream
+	w     io.Writer //if set, output goes here instead of Bytes
+	buf   []byte    //scratch space for string and integer headers
 }
 
+//RawBencode is an already bencoded value, such as the original bytes of
+//an info dict, that is written to the output unchanged. It must hold
+//exactly one valid bencoded object, which isn't checked.
+type RawBencode []byte
+
 func NewEncoder() *Encoder { return new(Encoder) }
 
+//Reset empties Encoder.Bytes but keeps its capacity, so that an Encoder
+//can be reused (e.g. through a sync.Pool) without allocating anew.
+func (enc *Encoder) Reset() {
+	enc.Bytes = enc.Bytes[:0]
+}
+
+//NewWriterEncoder creates an encoder that writes its output to w as it
+//is produced instead of collecting it in Encoder.Bytes. Only the keys of
+//a dict are held in memory while it is being encoded.
+//Output is written in small chunks, so w should be buffered.
+func NewWriterEncoder(w io.Writer) *Encoder { return &Encoder{w: w} }
+
 //Encode is a wrapper for Encoder.Encode.
 //It returns the bencoded byte stream.
-func Encode(in interface{}) []byte {
+func Encode(in interface{}) ([]byte, error) {
 	enc := NewEncoder()
-	enc.Encode(in)
-	return enc.Bytes
+	if err := enc.Encode(in); err != nil {
+		return nil, err
+	}
+	return enc.Bytes, nil
+}
+
+//AppendEncode appends the bencoding of in to dst and returns the extended
+//buffer, like append does. On error dst is returned unchanged.
+func AppendEncode(dst []byte, in interface{}) ([]byte, error) {
+	enc := &Encoder{Bytes: dst}
+	if err := enc.Encode(in); err != nil {
+		return dst, err
+	}
+	return enc.Bytes, nil
 }
 
 //Encode encodes an object into a bencoded byte stream.
 //The result of the operation is accessible through Encoder.Bytes.
+//If the object (or anything it contains) can't be encoded an error is
+//returned and Encoder.Bytes is left unchanged. Output that was already
+//written to the writer of a NewWriterEncoder can't be taken back though.
 //
 //Example:
 //	enc.Encode(23)
 //	enc.Encode("test")
 //	enc.Result //contains 'i23e4:test'
-func (enc *Encoder) Encode(in interface{}) {
-	if b := enc.encodeObject(in); len(b) > 0 {
+func (enc *Encoder) Encode(in interface{}) error {
+	n := len(enc.Bytes)
+	if err := enc.encodeObject(in); err != nil {
+		enc.Bytes = enc.Bytes[:n]
+		return err
+	}
+	return nil
+}
+
+//append b to the output
+func (enc *Encoder) write(b []byte) error {
+	if enc.w == nil {
 		enc.Bytes = append(enc.Bytes, b...)
+		return nil
 	}
+	_, err := enc.w.Write(b)
+	return err
 }
 
-func (enc *Encoder) encodeObject(in interface{}) []byte {
-    switch t := reflect.TypeOf(in); t.Kind() {
+//append s to the output
+func (enc *Encoder) writeString(s string) error {
+	if enc.w == nil {
+		enc.Bytes = append(enc.Bytes, s...)
+		return nil
+	}
+	_, err := io.WriteString(enc.w, s)
+	return err
+}
+
+func (enc *Encoder) encodeObject(in interface{}) error {
+	if in == nil {
+		return nil //skipped
+	}
+	switch t := reflect.TypeOf(in); t.Kind() {
 	case reflect.String:
-		return enc.encodeString(in.(string))
-	case reflect.Int64:
-		return enc.encodeInteger(in.(int64))
-	case reflect.Int:
-		return enc.encodeInteger(int64(in.(int)))
+		if s, ok := in.(string); ok {
+			return enc.encodeString(s)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return enc.encodeInteger(reflect.ValueOf(in).Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := reflect.ValueOf(in).Uint()
+		if u > math.MaxInt64 {
+			return fmt.Errorf("Integer overflows int64: %d", u)
+		}
+		return enc.encodeInteger(int64(u))
 	case reflect.Slice:
-		return enc.encodeList(in.([]interface{}))
+		if l, ok := in.([]interface{}); ok {
+			return enc.encodeList(l)
+		}
+		if b, ok := in.([]byte); ok {
+			return enc.encodeBytes(b)
+		}
+		if r, ok := in.(RawBencode); ok {
+			return enc.write(r)
+		}
+		if d, ok := in.(OrderedDict); ok {
+			return enc.encodeOrderedDict(d)
+		}
 	case reflect.Map:
-		return enc.encodeDict(in.(map[string]interface{}))
-	default:
-		panic(fmt.Errorf("Can't encode this type: %s", t.Name()))
+		if m, ok := in.(map[string]interface{}); ok {
+			return enc.encodeDict(m)
+		}
+	case reflect.Struct:
+		if tm, ok := in.(time.Time); ok {
+			return enc.encodeInteger(tm.Unix())
+		}
 	}
-	return nil
+	return fmt.Errorf("Can't encode this type: %s", reflect.TypeOf(in))
 }
 
-func (enc *Encoder) encodeString(s string) []byte {
-	if len(s) <= 0 {
-		return nil
+//the length header is built in enc.buf so that no allocation is needed
+//once the buffers have grown
+func (enc *Encoder) encodeString(s string) error {
+	enc.buf = strconv.AppendInt(enc.buf[:0], int64(len(s)), 10)
+	enc.buf = append(enc.buf, ':')
+	if err := enc.write(enc.buf); err != nil {
+		return err
 	}
-	return []byte(fmt.Sprintf("%d:%s", len(s), s))
+	return enc.writeString(s)
 }
 
-func (enc *Encoder) encodeInteger(i int64) []byte {
-	return []byte(fmt.Sprintf("i%de", i))
+//like encodeString, without converting b to a string
+func (enc *Encoder) encodeBytes(b []byte) error {
+	enc.buf = strconv.AppendInt(enc.buf[:0], int64(len(b)), 10)
+	enc.buf = append(enc.buf, ':')
+	if err := enc.write(enc.buf); err != nil {
+		return err
+	}
+	return enc.write(b)
 }
 
-func (enc *Encoder) encodeList(list []interface{}) []byte {
-	if len(list) <= 0 {
-		return nil
+func (enc *Encoder) encodeInteger(i int64) error {
+	enc.buf = append(enc.buf[:0], 'i')
+	enc.buf = strconv.AppendInt(enc.buf, i, 10)
+	enc.buf = append(enc.buf, 'e')
+	return enc.write(enc.buf)
+}
+
+func (enc *Encoder) encodeList(list []interface{}) error {
+	if err := enc.writeString("l"); err != nil {
+		return err
 	}
-	ret := []byte("l")
-    for _, obj := range list {
-		ret = append(ret, enc.encodeObject(obj)...)
+	for _, obj := range list {
+		if err := enc.encodeObject(obj); err != nil {
+			return err
+		}
 	}
-	ret = append(ret, 'e')
-	return ret
+	return enc.writeString("e")
 }
 
-func (enc *Encoder) encodeDict(m map[string]interface{}) []byte {
-	if len(m) <= 0 {
-		return nil
-	}
+func (enc *Encoder) encodeDict(m map[string]interface{}) error {
 	//sort the map >.<
-    keys := make([]string, 0, len(m))
+	keys := make([]string, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
 
-	ret := []byte("d")
+	if err := enc.writeString("d"); err != nil {
+		return err
+	}
 	for _, k := range keys {
-		ret = append(ret, enc.encodeString(k)...)
-		ret = append(ret, enc.encodeObject(m[k])...)
+		if m[k] == nil {
+			continue
+		}
+		if err := enc.encodeString(k); err != nil {
+			return err
+		}
+		if err := enc.encodeObject(m[k]); err != nil {
+			return err
+		}
+	}
+	return enc.writeString("e")
+}
+
+//encode the entries of d in their given order
+func (enc *Encoder) encodeOrderedDict(d OrderedDict) error {
+	if err := enc.writeString("d"); err != nil {
+		return err
+	}
+	for _, e := range d {
+		if e.Value == nil {
+			continue
+		}
+		if err := enc.encodeString(e.Key); err != nil {
+			return err
+		}
+		if err := enc.encodeObject(e.Value); err != nil {
+			return err
+		}
 	}
-	ret = append(ret, 'e')
-	return ret
+	return enc.writeString("e")
 }
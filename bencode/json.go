@@ -0,0 +1,129 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//key of the JSON object standing in for a string that isn't valid UTF-8
+const jsonBase64Key = "$base64"
+
+//prefix of a base64 encoded dict key that isn't valid UTF-8
+const jsonBase64KeyPrefix = "$base64:"
+
+//ToJSON converts the single bencoded object in data to JSON.
+//Strings that aren't valid UTF-8 (like the pieces of a torrent) become
+//objects of the form {"$base64": "..."}. Such dict keys, like the info
+//hashes of a scrape, become "$base64:..." keys, as do the keys "$base64"
+//and those that already start with "$base64:".
+func ToJSON(data []byte) ([]byte, error) {
+	o, err := DecodeSingle(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(toJSONValue(o))
+}
+
+func toJSONValue(o interface{}) interface{} {
+	switch v := o.(type) {
+	case string:
+		if !utf8.ValidString(v) {
+			return map[string]string{jsonBase64Key: base64.StdEncoding.EncodeToString([]byte(v))}
+		}
+		return v
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = toJSONValue(e)
+		}
+		return l
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			//escaping a "$base64" key keeps the dict apart from a wrapper
+			if !utf8.ValidString(k) || k == jsonBase64Key || strings.HasPrefix(k, jsonBase64KeyPrefix) {
+				k = jsonBase64KeyPrefix + base64.StdEncoding.EncodeToString([]byte(k))
+			}
+			m[k] = toJSONValue(e)
+		}
+		return m
+	}
+	return o
+}
+
+//FromJSON is the inverse of ToJSON. It converts a JSON document to
+//bencode. Numbers must be integers; booleans, null and floats can't be
+//represented in bencode and are rejected. An object whose only key is
+//"$base64" is decoded as a binary string, and so are keys starting with
+//"$base64:".
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("Trailing data after JSON value")
+	}
+	o, err := fromJSONValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(o)
+}
+
+func fromJSONValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("Not an integer: %s", v)
+		}
+		return i, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			o, err := fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = o
+		}
+		return l, nil
+	case map[string]interface{}:
+		if s, ok := v[jsonBase64Key].(string); ok && len(v) == 1 {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if strings.HasPrefix(k, jsonBase64KeyPrefix) {
+				b, err := base64.StdEncoding.DecodeString(k[len(jsonBase64KeyPrefix):])
+				if err != nil {
+					return nil, err
+				}
+				k = string(b)
+			}
+			if _, ok := m[k]; ok {
+				return nil, fmt.Errorf("Duplicate key %q", k)
+			}
+			o, err := fromJSONValue(e)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = o
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("Can't convert JSON value to bencode: %#v", v)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+//piece bitfields
+
+//A Bitfield records which pieces of a torrent are present. Its byte
+//layout is that of the peer protocol's bitfield message: the most
+//significant bit of byte 0 is piece 0.
+type Bitfield struct {
+	bits []byte
+	n    int //number of pieces
+	set  int //number of set bits
+}
+
+//NewBitfield returns an empty bitfield for numPieces pieces.
+func NewBitfield(numPieces int) *Bitfield {
+	return &Bitfield{bits: make([]byte, (numPieces+7)/8), n: numPieces}
+}
+
+//ParseBitfield returns the bitfield of numPieces pieces in data, the
+//payload of a bitfield message. data must be exactly (numPieces+7)/8 bytes
+//long and its spare bits after the last piece must be zero.
+func ParseBitfield(data []byte, numPieces int) (*Bitfield, error) {
+	if numPieces < 0 {
+		return nil, fmt.Errorf("Invalid number of pieces %d", numPieces)
+	}
+	bf := NewBitfield(numPieces)
+	if len(data) != len(bf.bits) {
+		return nil, fmt.Errorf("Bitfield is %d bytes long, expected %d", len(data), len(bf.bits))
+	}
+	if spare := numPieces % 8; spare != 0 && data[len(data)-1]&(0xff>>uint(spare)) != 0 {
+		return nil, errors.New("Bitfield has spare bits set")
+	}
+	copy(bf.bits, data)
+	for _, b := range bf.bits {
+		bf.set += bits.OnesCount8(b)
+	}
+	return bf, nil
+}
+
+//Len returns the number of pieces.
+func (bf *Bitfield) Len() int { return bf.n }
+
+//Set marks piece i as present. It panics if i is out of range.
+func (bf *Bitfield) Set(i int) {
+	bf.check(i)
+	mask := byte(0x80) >> uint(i%8)
+	if bf.bits[i/8]&mask == 0 {
+		bf.bits[i/8] |= mask
+		bf.set++
+	}
+}
+
+//Has reports whether piece i is present. It panics if i is out of range.
+func (bf *Bitfield) Has(i int) bool {
+	bf.check(i)
+	return bf.bits[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+//Complete reports whether all pieces are present.
+func (bf *Bitfield) Complete() bool { return bf.set == bf.n }
+
+//Bytes returns the bitfield in wire format. The spare bits of the last
+//byte are zero.
+func (bf *Bitfield) Bytes() []byte {
+	return append([]byte(nil), bf.bits...)
+}
+
+func (bf *Bitfield) check(i int) {
+	if i < 0 || i >= bf.n {
+		panic("Bitfield index out of range")
+	}
+}
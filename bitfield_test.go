@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitfield(t *testing.T) {
+	bf := NewBitfield(10)
+	if bf.Complete() {
+		t.Errorf("Complete: empty bitfield is complete")
+	}
+	for _, i := range []int{0, 7, 8, 9} {
+		bf.Set(i)
+	}
+	bf.Set(7) //setting twice doesn't count twice
+	for i := 0; i < 10; i++ {
+		if exp := i == 0 || i >= 7; bf.Has(i) != exp {
+			t.Errorf("Has(%d): expected %v", i, exp)
+		}
+	}
+	if b, exp := bf.Bytes(), []byte{0x81, 0xc0}; !bytes.Equal(b, exp) {
+		t.Errorf("Bytes: expected %x, got %x", exp, b)
+	}
+	if bf.Complete() {
+		t.Errorf("Complete: bitfield with missing pieces is complete")
+	}
+	for i := 1; i < 7; i++ {
+		bf.Set(i)
+	}
+	if !bf.Complete() {
+		t.Errorf("Complete: expected complete bitfield")
+	}
+	if b, exp := bf.Bytes(), []byte{0xff, 0xc0}; !bytes.Equal(b, exp) {
+		t.Errorf("Bytes: expected %x with zero padding, got %x", exp, b)
+	}
+
+	if b := NewBitfield(16).Bytes(); len(b) != 2 {
+		t.Errorf("Bytes: expected 2 bytes for 16 pieces, got %d", len(b))
+	}
+	if !NewBitfield(0).Complete() {
+		t.Errorf("Complete: expected empty torrent to be complete")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Set: expected panic for index out of range")
+		}
+	}()
+	bf.Set(10)
+}
+
+func TestParseBitfield(t *testing.T) {
+	bf, err := ParseBitfield([]byte{0x81, 0xc0}, 10)
+	if err != nil {
+		t.Fatalf("ParseBitfield: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if exp := i == 0 || i >= 7; bf.Has(i) != exp {
+			t.Errorf("Has(%d): expected %v", i, exp)
+		}
+	}
+	for _, i := range []int{1, 2, 3, 4, 5, 6} {
+		bf.Set(i)
+	}
+	if !bf.Complete() {
+		t.Errorf("Complete: parsed bitfield with all pieces set isn't complete")
+	}
+	if bf, err = ParseBitfield([]byte{0xff}, 8); err != nil || !bf.Complete() {
+		t.Errorf("ParseBitfield: expected complete bitfield without spare bits, got %v", err)
+	}
+
+	if _, err = ParseBitfield([]byte{0x81}, 10); err == nil {
+		t.Errorf("ParseBitfield: expected error for short bitfield")
+	}
+	if _, err = ParseBitfield([]byte{0x81, 0xc0, 0x00}, 10); err == nil {
+		t.Errorf("ParseBitfield: expected error for long bitfield")
+	}
+	if _, err = ParseBitfield([]byte{0x81, 0xe0}, 10); err == nil {
+		t.Errorf("ParseBitfield: expected error for spare bits set")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//torrent creation
+
+//bounds and target piece count of RecommendPieceLength
+const (
+	minPieceLength   = 16 * 1024
+	maxPieceLength   = 16 * 1024 * 1024
+	targetPieceCount = 2000
+)
+
+//RecommendPieceLength returns a piece length for content of totalSize
+//bytes: the smallest power of two from 16KiB up to 16MiB that keeps the
+//number of pieces at about targetPieceCount or below. Larger pieces
+//make for smaller metainfo, smaller ones for less overhead when
+//verifying and transferring.
+func RecommendPieceLength(totalSize int64) int64 {
+	pl := int64(minPieceLength)
+	for pl < maxPieceLength && totalSize/pl > targetPieceCount {
+		pl *= 2
+	}
+	return pl
+}
+
+//CreateOption configures optional behaviour of CreateFromFile and CreateFromDir.
+type CreateOption func(*createOptions)
+
+type createOptions struct {
+	followSymlinks bool
+	private        bool
+	source         string
+	creationDate   time.Time //zero to leave out
+	createdBy      string    //empty to leave out
+}
+
+//created by of new torrents unless set with CreatedBy
+const createdByDefault = "gorrent"
+
+//FollowSymlinks makes CreateFromDir include the targets of symbolic links.
+//By default symbolic links are skipped.
+func FollowSymlinks(follow bool) CreateOption {
+	return func(o *createOptions) { o.followSymlinks = follow }
+}
+
+//Private marks the torrent as private (BEP 27), so clients only get
+//peers from its trackers. This is part of the info dict and changes the
+//info hash.
+func Private(private bool) CreateOption {
+	return func(o *createOptions) { o.private = private }
+}
+
+//Source sets info.source of the torrent, see MetaInfo.SetSource.
+func Source(source string) CreateOption {
+	return func(o *createOptions) { o.source = source }
+}
+
+//CreationDate sets the creation date of the torrent, which defaults to
+//the current time. The zero time leaves it out, e.g. for reproducible
+//torrents.
+func CreationDate(t time.Time) CreateOption {
+	return func(o *createOptions) { o.creationDate = t }
+}
+
+//CreatedBy sets the created by field of the torrent, which defaults to
+//"gorrent". An empty string leaves it out.
+func CreatedBy(createdBy string) CreateOption {
+	return func(o *createOptions) { o.createdBy = createdBy }
+}
+
+func makeCreateOptions(opts []CreateOption) *createOptions {
+	o := &createOptions{creationDate: time.Now(), createdBy: createdByDefault}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+//CreateFromFile builds the metainfo for a single-file torrent.
+//The file is read piece by piece, so it is never held in memory as a whole.
+//A pieceLength of 0 selects RecommendPieceLength for the content size.
+func CreateFromFile(path string, pieceLength int64, announce string, opts ...CreateOption) (*MetaInfo, error) {
+	o := makeCreateOptions(opts)
+	if pieceLength < 0 {
+		return nil, errors.New("Piece length must not be negative")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, errors.New("Not a regular file: " + path)
+	}
+	if pieceLength == 0 {
+		pieceLength = RecommendPieceLength(fi.Size())
+	}
+
+	pieces, length, err := hashPieces(f, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{
+		"name":         filepath.Base(path),
+		"length":       length,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	return newMetaInfo(info, announce, o), nil
+}
+
+//CreateFromDir builds the metainfo for a multi-file torrent containing
+//every regular file below dir. Files are ordered by path and hashed as one
+//continuous stream, so pieces may span file boundaries.
+//A pieceLength of 0 selects RecommendPieceLength for the content size.
+func CreateFromDir(dir string, pieceLength int64, announce string, opts ...CreateOption) (*MetaInfo, error) {
+	o := makeCreateOptions(opts)
+	if pieceLength < 0 {
+		return nil, errors.New("Piece length must not be negative")
+	}
+
+	entries, err := walkDir(dir, nil, o.followSymlinks, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("No files found in " + dir)
+	}
+	if pieceLength == 0 {
+		var size int64
+		for _, e := range entries {
+			size += e.length
+		}
+		pieceLength = RecommendPieceLength(size)
+	}
+
+	chain := &fileChain{entries: entries}
+	defer chain.Close()
+	pieces, length, err := hashPieces(chain, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	files := make([]interface{}, 0, len(entries))
+	for _, e := range entries {
+		path := make([]interface{}, len(e.path))
+		for i, c := range e.path {
+			path[i] = c
+		}
+		files = append(files, map[string]interface{}{
+			"length": e.length,
+			"path":   path,
+		})
+		total += e.length
+	}
+	if total != length {
+		return nil, errors.New("Files changed while hashing " + dir)
+	}
+
+	info := map[string]interface{}{
+		"name":         filepath.Base(filepath.Clean(dir)),
+		"files":        files,
+		"piece length": pieceLength,
+		"pieces":       pieces,
+	}
+	return newMetaInfo(info, announce, o), nil
+}
+
+//a file found by walkDir
+type fileEntry struct {
+	osPath string   //path on disk
+	path   []string //path components relative to the torrent root
+	length int64
+}
+
+//recursively collect the regular files below dir in sorted path order.
+//visited holds the resolved directories already walked so that
+//symlink cycles are not followed forever.
+func walkDir(dir string, prefix []string, follow bool, visited map[string]bool) ([]fileEntry, error) {
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	if visited[real] {
+		return nil, nil
+	}
+	visited[real] = true
+
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var entries []fileEntry
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return nil, err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if !follow {
+				continue
+			}
+			if fi, err = os.Stat(p); err != nil {
+				return nil, err
+			}
+		}
+
+		path := append(append([]string(nil), prefix...), name)
+		switch {
+		case fi.IsDir():
+			sub, err := walkDir(p, path, follow, visited)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, sub...)
+		case fi.Mode().IsRegular():
+			entries = append(entries, fileEntry{p, path, fi.Size()})
+		}
+	}
+	return entries, nil
+}
+
+//fileChain reads the content of a list of files as one stream.
+//Only the file currently being read is open.
+type fileChain struct {
+	entries []fileEntry
+	cur     *os.File
+}
+
+func (c *fileChain) Read(p []byte) (int, error) {
+	for {
+		if c.cur == nil {
+			if len(c.entries) == 0 {
+				return 0, io.EOF
+			}
+			f, err := os.Open(c.entries[0].osPath)
+			if err != nil {
+				return 0, err
+			}
+			c.cur = f
+			c.entries = c.entries[1:]
+		}
+		n, err := c.cur.Read(p)
+		if err == io.EOF {
+			c.cur.Close()
+			c.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *fileChain) Close() error {
+	if c.cur == nil {
+		return nil
+	}
+	err := c.cur.Close()
+	c.cur = nil
+	return err
+}
+
+//wrap an info dict into a new MetaInfo
+func newMetaInfo(info map[string]interface{}, announce string, o *createOptions) *MetaInfo {
+	if o.private {
+		info["private"] = int64(1)
+	}
+	if o.source != "" {
+		info["source"] = o.source
+	}
+	parsed := map[string]interface{}{"info": info}
+	if announce != "" {
+		parsed["announce"] = announce
+	}
+	if !o.creationDate.IsZero() {
+		parsed["creation date"] = o.creationDate.Unix()
+	}
+	if o.createdBy != "" {
+		parsed["created by"] = o.createdBy
+	}
+	return &MetaInfo{parsed: parsed}
+}
+
+//read r until EOF and return the concatenated sha1 hashes of each
+//pieceLength sized chunk together with the total number of bytes read.
+//only one piece is buffered at a time.
+func hashPieces(r io.Reader, pieceLength int64) (pieces string, length int64, err error) {
+	var hashes []byte
+	buf := make([]byte, pieceLength)
+	hasher := sha1.New()
+	for {
+		n, e := io.ReadFull(r, buf)
+		if n > 0 {
+			hasher.Reset()
+			hasher.Write(buf[:n])
+			hashes = hasher.Sum(hashes)
+			length += int64(n)
+		}
+		if e == io.EOF || e == io.ErrUnexpectedEOF {
+			break
+		}
+		if e != nil {
+			return "", 0, e
+		}
+	}
+	return string(hashes), length, nil
+}
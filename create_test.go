@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/sha1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCreateFromFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.bin")
+	data := make([]byte, 100000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mi, err := CreateFromFile(filename, 16384, "http://tracker/announce")
+	if err != nil {
+		t.Fatalf("CreateFromFile: %v", err)
+	}
+	info := mi.parsed["info"].(map[string]interface{})
+	if n := len(info["pieces"].(string)) / 20; n != 7 {
+		t.Errorf("CreateFromFile: expected 7 pieces, got %d", n)
+	}
+	if l := info["length"].(int64); l != int64(len(data)) {
+		t.Errorf("CreateFromFile: expected length %d, got %d", len(data), l)
+	}
+	if name := info["name"].(string); name != "data.bin" {
+		t.Errorf("CreateFromFile: expected name data.bin, got %s", name)
+	}
+	if mi.parsed["announce"] != "http://tracker/announce" {
+		t.Errorf("CreateFromFile: unexpected announce %v", mi.parsed["announce"])
+	}
+
+	mi, err = CreateFromFile(filename, 0, "")
+	if err != nil {
+		t.Fatalf("CreateFromFile: %v", err)
+	}
+	info = mi.parsed["info"].(map[string]interface{})
+	if pl := info["piece length"].(int64); pl != RecommendPieceLength(int64(len(data))) {
+		t.Errorf("CreateFromFile: expected recommended piece length, got %d", pl)
+	}
+	if _, ok := mi.parsed["announce"]; ok {
+		t.Errorf("CreateFromFile: unexpected announce key")
+	}
+}
+
+func TestCreateFromDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	//with a piece length of 16 the second file starts at offset 10
+	//and the boundary at 32 falls in its middle
+	a := []byte("0123456789")
+	b := []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJ")
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.txt"), a, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "sub", "b.txt"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "a.txt"), filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	mi, err := CreateFromDir(dir, 16, "http://tracker/announce")
+	if err != nil {
+		t.Fatalf("CreateFromDir: %v", err)
+	}
+	info := mi.parsed["info"].(map[string]interface{})
+	if name := info["name"].(string); name != "content" {
+		t.Errorf("CreateFromDir: expected name content, got %s", name)
+	}
+
+	all := append(append([]byte(nil), a...), b...)
+	pieces := info["pieces"].(string)
+	if len(pieces) != 20*3 {
+		t.Fatalf("CreateFromDir: expected 3 pieces, got %d", len(pieces)/20)
+	}
+	for i := 0; i < 3; i++ {
+		end := (i + 1) * 16
+		if end > len(all) {
+			end = len(all)
+		}
+		h := sha1.Sum(all[i*16 : end])
+		if pieces[i*20:(i+1)*20] != string(h[:]) {
+			t.Errorf("CreateFromDir: wrong hash for piece %d", i)
+		}
+	}
+
+	files := info["files"].([]interface{})
+	if len(files) != 2 {
+		t.Fatalf("CreateFromDir: expected 2 files, got %d", len(files))
+	}
+	f := files[1].(map[string]interface{})
+	path := f["path"].([]interface{})
+	if len(path) != 2 || path[0] != "sub" || path[1] != "b.txt" {
+		t.Errorf("CreateFromDir: unexpected path %v", path)
+	}
+	if l := f["length"].(int64); l != int64(len(b)) {
+		t.Errorf("CreateFromDir: expected length %d, got %d", len(b), l)
+	}
+
+	mi, err = CreateFromDir(dir, 16, "", FollowSymlinks(true))
+	if err != nil {
+		t.Fatalf("CreateFromDir: %v", err)
+	}
+	info = mi.parsed["info"].(map[string]interface{})
+	if files = info["files"].([]interface{}); len(files) != 3 {
+		t.Errorf("CreateFromDir: expected 3 files with symlinks followed, got %d", len(files))
+	}
+}
+
+func TestCreatePrivate(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.bin")
+	if err := ioutil.WriteFile(filename, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	public, err := CreateFromFile(filename, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := CreateFromFile(filename, 0, "", Private(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if public.IsPrivate() || !private.IsPrivate() {
+		t.Errorf("CreateFromFile: private flag not honored")
+	}
+	if string(mustInfoHash(t, public)) == string(mustInfoHash(t, private)) {
+		t.Errorf("CreateFromFile: private flag didn't change the info hash")
+	}
+
+	mi, err := CreateFromDir(filepath.Dir(filename), 0, "", Private(true))
+	if err != nil || !mi.IsPrivate() {
+		t.Errorf("CreateFromDir: private flag not honored (%v)", err)
+	}
+}
+
+func TestCreateSource(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.bin")
+	if err := ioutil.WriteFile(filename, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := CreateFromFile(filename, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := CreateFromFile(filename, 0, "", Source("TRACKER"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := mi.infoDict(); info["source"] != "TRACKER" {
+		t.Errorf("CreateFromFile: expected source TRACKER, got %v", info["source"])
+	}
+	if string(mustInfoHash(t, plain)) == string(mustInfoHash(t, mi)) {
+		t.Errorf("CreateFromFile: source didn't change the info hash")
+	}
+}
+
+func TestCreateReproducible(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".bin"), []byte("the same content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mi, err := CreateFromFile(filepath.Join(dir, "a.bin"), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := mi.parsed["creation date"].(int64); !ok || time.Since(time.Unix(d, 0)) > time.Minute {
+		t.Errorf("CreateFromFile: expected current creation date, got %v", mi.parsed["creation date"])
+	}
+	if mi.parsed["created by"] != "gorrent" {
+		t.Errorf("CreateFromFile: expected created by gorrent, got %v", mi.parsed["created by"])
+	}
+
+	date := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var sums [2][sha1.Size]byte
+	for i := range sums {
+		//identical content under the same name
+		sub := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(sub, "data.bin")
+		if err := ioutil.WriteFile(filename, []byte("the same content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mi, err := CreateFromFile(filename, 0, "http://tracker/announce", CreationDate(date), CreatedBy("test"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mi.parsed["creation date"] != date.Unix() || mi.parsed["created by"] != "test" {
+			t.Errorf("CreateFromFile: unexpected creation date %v or created by %v", mi.parsed["creation date"], mi.parsed["created by"])
+		}
+		out := filepath.Join(sub, "out.torrent")
+		if err := mi.WriteToFile(out); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums[i] = sha1.Sum(b)
+	}
+	if sums[0] != sums[1] {
+		t.Errorf("CreateFromFile: torrents with a fixed creation date differ")
+	}
+
+	mi, err = CreateFromFile(filepath.Join(dir, "b.bin"), 0, "", CreationDate(time.Time{}), CreatedBy(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mi.parsed["creation date"]; ok {
+		t.Errorf("CreateFromFile: unexpected creation date")
+	}
+	if _, ok := mi.parsed["created by"]; ok {
+		t.Errorf("CreateFromFile: unexpected created by")
+	}
+}
+
+//an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestHashPiecesBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("hashes 256MiB")
+	}
+	const size = 256 << 20
+	const pieceLength = 1 << 20
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	pieces, length, err := hashPieces(io.LimitReader(zeroReader{}, size), pieceLength)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatalf("hashPieces: %v", err)
+	}
+	if length != size {
+		t.Errorf("hashPieces: expected length %d, got %d", size, length)
+	}
+	if n := len(pieces) / 20; n != size/pieceLength {
+		t.Errorf("hashPieces: expected %d pieces, got %d", size/pieceLength, n)
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8*pieceLength {
+		t.Errorf("hashPieces: allocated %d bytes for a %d byte input", alloc, size)
+	}
+}
+
+func TestRecommendPieceLength(t *testing.T) {
+	const (
+		KiB = 1024
+		MiB = 1024 * KiB
+		GiB = 1024 * MiB
+	)
+	for _, c := range []struct {
+		size, exp int64
+	}{
+		{0, 16 * KiB},
+		{1 * MiB, 16 * KiB},
+		{1 * GiB, 1 * MiB},
+		{50 * GiB, 16 * MiB},
+	} {
+		if pl := RecommendPieceLength(c.size); pl != c.exp {
+			t.Errorf("RecommendPieceLength(%d): expected %d, got %d", c.size, c.exp, pl)
+		}
+	}
+}
@@ -81,7 +81,11 @@ func main() {
 	}
 
 	//fmt.Printf("%#v\n", torrent.parsed)
-	b := torrent.InfoHash()
+	b, err := torrent.InfoHash()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	s := rfc1738_encode(string(b))
 	fmt.Printf("%s\n", s)
 
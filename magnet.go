@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/url"
+)
+
+//MagnetURI returns a magnet link for the torrent.
+//It carries the hex encoded info hash, the display name (see Name) and
+//every tracker from announce and announce-list.
+func (mi *MetaInfo) MagnetURI() (string, error) {
+	hash, err := mi.InfoHash()
+	if err != nil {
+		return "", err
+	}
+	name := mi.Name()
+	if name == "" {
+		return "", errors.New("Torrent has no name")
+	}
+
+	uri := "magnet:?xt=urn:btih:" + hex.EncodeToString(hash)
+	uri += "&dn=" + url.QueryEscape(name)
+
+	var trackers []string
+	if announce, ok := mi.parsed["announce"].(string); ok && announce != "" {
+		trackers = append(trackers, announce)
+	}
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return "", err
+	}
+	for _, tier := range tiers {
+		trackers = append(trackers, tier...)
+	}
+
+	seen := make(map[string]bool)
+	for _, tr := range trackers {
+		if seen[tr] {
+			continue
+		}
+		seen[tr] = true
+		uri += "&tr=" + url.QueryEscape(tr)
+	}
+	return uri, nil
+}
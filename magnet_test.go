@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMagnetURI(t *testing.T) {
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce": "http://a/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a/announce", "http://b/announce"},
+			[]interface{}{"udp://c:80/a"},
+		},
+		"info": map[string]interface{}{
+			"length":       5,
+			"name":         "my file!",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+		},
+	})
+
+	uri, err := mi.MagnetURI()
+	if err != nil {
+		t.Fatalf("MagnetURI: %v", err)
+	}
+	if !strings.HasPrefix(uri, "magnet:?") {
+		t.Fatalf("MagnetURI: unexpected uri %s", uri)
+	}
+	q, err := url.ParseQuery(uri[len("magnet:?"):])
+	if err != nil {
+		t.Fatalf("MagnetURI: couldn't parse %s: %v", uri, err)
+	}
+
+	xt := "urn:btih:" + hex.EncodeToString(mustInfoHash(t, mi))
+	if q.Get("xt") != xt || len(q.Get("xt")) != len("urn:btih:")+40 {
+		t.Errorf("MagnetURI: expected xt %s, got %s", xt, q.Get("xt"))
+	}
+	if q.Get("dn") != "my file!" {
+		t.Errorf("MagnetURI: expected dn 'my file!', got '%s'", q.Get("dn"))
+	}
+	tr := q["tr"]
+	exp := []string{"http://a/announce", "http://b/announce", "udp://c:80/a"}
+	if len(tr) != len(exp) {
+		t.Fatalf("MagnetURI: expected trackers %v, got %v", exp, tr)
+	}
+	for i := range exp {
+		if tr[i] != exp[i] {
+			t.Errorf("MagnetURI: expected trackers %v, got %v", exp, tr)
+			break
+		}
+	}
+}
+
+func TestMagnetURIUTF8Name(t *testing.T) {
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"info": map[string]interface{}{
+			"length":       5,
+			"name":         "caf\xe9",
+			"name.utf-8":   "café",
+			"piece length": 16384,
+			"pieces":       strings.Repeat("x", 20),
+		},
+	})
+	uri, err := mi.MagnetURI()
+	if err != nil {
+		t.Fatalf("MagnetURI: %v", err)
+	}
+	q, err := url.ParseQuery(uri[len("magnet:?"):])
+	if err != nil {
+		t.Fatalf("MagnetURI: couldn't parse %s: %v", uri, err)
+	}
+	if q.Get("dn") != "café" {
+		t.Errorf("MagnetURI: expected dn 'café' from name.utf-8, got '%s'", q.Get("dn"))
+	}
+}
+
+func TestMagnetURIInfoHashError(t *testing.T) {
+	mi := &MetaInfo{parsed: map[string]interface{}{
+		"info": map[string]interface{}{"name": "x", "length": 1.5}, //floats can't be encoded
+	}}
+	if uri, err := mi.MagnetURI(); err == nil {
+		t.Errorf("MagnetURI: expected error without info hash, got %s", uri)
+	}
+}
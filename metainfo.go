@@ -1,46 +1,1197 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"gorrent/bencode"
+	"hash"
+	"io"
 	"io/ioutil"
-	//"bytes"
-	//"fmt"
-	"crypto/sha1"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 //metainfo file (.torrent file) handling
 
 type MetaInfo struct {
 	raw    []byte
+	info   []byte //the original bytes of the info dict within raw
+	key    string //cached result of Key
 	parsed map[string]interface{}
 }
 
+//ReadFromFile reads and parses the torrent file filename, which may be
+//gzip compressed (see ReadFrom).
 func (mi *MetaInfo) ReadFromFile(filename string) error {
-	b, err := ioutil.ReadFile(filename)
+	f, err := os.Open(filename)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
+	_, err = mi.ReadFrom(f)
+	return err
+}
+
+//ReadFrom reads a torrent from r until EOF and parses it.
+//It implements io.ReaderFrom and returns the number of bytes read.
+//gzip compressed input, like a .torrent.gz, is recognized by its magic
+//bytes and decompressed transparently.
+func (mi *MetaInfo) ReadFrom(r io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	n := int64(len(b))
+	if err != nil {
+		return n, err
+	}
+	if bytes.HasPrefix(b, gzipMagic) {
+		if b, err = gunzip(b); err != nil {
+			return n, errors.New("Couldn't decompress torrent: " + err.Error())
+		}
+	}
+	return n, mi.parse(b)
+}
+
+//the first bytes of gzip data. a bencoded torrent starts with a 'd'.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+//decode the bencoded torrent b
+func (mi *MetaInfo) parse(b []byte) error {
 	mi.raw = b
 
+	//a repeated key, like a second info dict, would let the hashed bytes
+	//and the parsed values disagree
 	dec := bencode.NewDecoder(b)
+	dec.NoDuplicateKeys = true
+	dec.SkipWhitespace = true
 	o, err := dec.Decode()
+	if err == nil && !dec.Consumed {
+		err = errors.New("Trailing data after torrent")
+	}
 	if err != nil {
-		return errors.New("Couldn't parse torrent: " + err.Error())
+		return fmt.Errorf("Couldn't parse torrent: %w", err)
 	}
 
-	mi.parsed = o.(map[string]interface{})
-	return nil
+	d, ok := o.(map[string]interface{})
+	if !ok {
+		return errors.New("Couldn't parse torrent: not a dict")
+	}
+	mi.parsed = d
+
+	//keep the info dict as it was encoded in the file. re-encoding
+	//the parsed map could reorder keys and yield a different hash.
+	mi.info = nil
+	mi.key = ""
+	if _, ok := mi.parsed["info"]; ok {
+		if mi.info, err = bencode.RawDictValue(b, "info"); err != nil {
+			return fmt.Errorf("Couldn't parse torrent: %w", err)
+		}
+	}
+	return mi.Validate()
 }
 
-//return sha1 info_hash
-func (mi *MetaInfo) InfoHash() []byte {
-	d := mi.parsed["info"].(map[string]interface{})
-	b := bencode.Encode(d)
+//Validate checks that the metainfo has the structure required by the
+//specification. All problems found are reported in the returned error.
+func (mi *MetaInfo) Validate() error {
+	var errs []error
+	info, ok := mi.parsed["info"].(map[string]interface{})
+	if !ok {
+		return errors.New("info is missing or not a dict")
+	}
+	if _, ok := info["name"].(string); !ok {
+		errs = append(errs, errors.New("info.name is missing or not a string"))
+	}
+	if pl, ok := info["piece length"].(int64); !ok || pl <= 0 {
+		errs = append(errs, errors.New("info.piece length is missing or not a positive integer"))
+	}
+	if mi.MetaVersion() == 2 {
+		if _, ok := info["file tree"].(map[string]interface{}); !ok {
+			errs = append(errs, errors.New("info.file tree is missing or not a dict"))
+		}
+		if _, ok := info["pieces"]; !ok {
+			//v2 only, the v1 keys below don't apply
+			return errors.Join(errs...)
+		}
+	}
+	if pieces, ok := info["pieces"].(string); !ok {
+		errs = append(errs, errors.New("info.pieces is missing or not a string"))
+	} else if len(pieces)%20 != 0 {
+		errs = append(errs, errors.New("info.pieces length is not a multiple of 20"))
+	}
 
-	//sha1
-	hasher := sha1.New()
+	_, hasLength := info["length"]
+	_, hasFiles := info["files"]
+	switch {
+	case hasLength && hasFiles:
+		errs = append(errs, errors.New("info has both length and files"))
+	case hasLength:
+		if l, ok := info["length"].(int64); !ok || l < 0 {
+			errs = append(errs, errors.New("info.length is not a non-negative integer"))
+		}
+	case hasFiles:
+		if _, ok := info["files"].([]interface{}); !ok {
+			errs = append(errs, errors.New("info.files is not a list"))
+		}
+	default:
+		errs = append(errs, errors.New("info has neither length nor files"))
+	}
+	return errors.Join(errs...)
+}
+
+//WriteToFile bencodes the metainfo and writes it to filename.
+//The info dict of a torrent that was read is written as it was, so the
+//info hash doesn't change even if its keys weren't sorted.
+//The data is written to a temporary file first and renamed into place,
+//so an existing file is never left half-written. The file gets the mode
+//of the file it replaces, 0644 for a new file.
+func (mi *MetaInfo) WriteToFile(filename string) error {
+	if mi.parsed == nil {
+		return errors.New("No metainfo to write")
+	}
+	parsed := mi.parsed
+	if mi.info != nil {
+		parsed = make(map[string]interface{}, len(mi.parsed))
+		for k, v := range mi.parsed {
+			parsed[k] = v
+		}
+		parsed["info"] = bencode.RawBencode(mi.info)
+	}
+	b, err := bencode.Encode(parsed)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f, err := ioutil.TempFile(filepath.Dir(filename), ".gorrent-")
+	if err != nil {
+		return err
+	}
+	if err = f.Chmod(mode); err == nil {
+		_, err = f.Write(b)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+//InfoBytes returns the bencoded info dict exactly as it appeared in the
+//torrent that was read, e.g. for signing it. For torrents built in memory
+//it's the encoding of the info dict.
+func (mi *MetaInfo) InfoBytes() ([]byte, error) {
+	if mi.info != nil {
+		return mi.info, nil
+	}
+	info, err := mi.infoDict()
+	if err != nil {
+		return nil, err
+	}
+	return bencode.Encode(info)
+}
+
+//return sha1 info_hash.
+//for hybrid v1/v2 torrents (BEP 52) this is the v1 info hash, which covers
+//the whole info dict including its v2 keys.
+//It fails if the info dict is missing or empty.
+func (mi *MetaInfo) InfoHash() ([]byte, error) {
+	return mi.InfoHashWith(sha1.New)
+}
+
+//InfoHashWith returns the hash of the info dict computed with the hash
+//function h, sha1 if h is nil. With sha256.New it is the v2 info hash
+//(BEP 52), which is truncated to 20 bytes where a v1 hash is expected.
+//It fails if the info dict is missing or empty.
+func (mi *MetaInfo) InfoHashWith(h func() hash.Hash) ([]byte, error) {
+	info, err := mi.infoDict()
+	if err != nil {
+		return nil, err
+	}
+	if len(info) == 0 {
+		return nil, errors.New("Torrent has an empty info dict")
+	}
+	b, err := mi.InfoBytes()
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		h = sha1.New
+	}
+	hasher := h()
 	hasher.Write(b)
-	//s := fmt.Sprintf("%x", hasher.Sum())
-	return hasher.Sum(nil)
+	return hasher.Sum(nil), nil
+}
+
+//InfoHashHex returns the info hash in hex, as used in logs and by
+//trackers. It returns "" if there is no info hash.
+func (mi *MetaInfo) InfoHashHex() string {
+	h, _ := mi.InfoHash()
+	return hex.EncodeToString(h)
+}
+
+//Key returns a stable identifier of the torrent's content for use as a
+//map or sort key: its hex info hash. It is computed once and cached.
+func (mi *MetaInfo) Key() string {
+	if mi.key == "" {
+		mi.key = mi.InfoHashHex()
+	}
+	return mi.key
+}
+
+//InfoHashBase32 returns the info hash in unpadded uppercase base32, the
+//form some magnet links use. It returns "" if there is no info hash.
+func (mi *MetaInfo) InfoHashBase32() string {
+	h, _ := mi.InfoHash()
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(h)
+}
+
+//ErrNoTracker is returned when a torrent has no tracker, as is the case
+//for trackerless (DHT only) torrents.
+var ErrNoTracker = errors.New("Torrent has no tracker")
+
+//Announce returns the url of the torrent's tracker: announce, or if that
+//is missing the first url of the announce-list. A torrent without any
+//tracker yields ErrNoTracker.
+func (mi *MetaInfo) Announce() (string, error) {
+	switch v := mi.parsed["announce"].(type) {
+	case nil:
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	default:
+		return "", errors.New("announce is not a string")
+	}
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return "", err
+	}
+	for _, tier := range tiers {
+		if len(tier) > 0 {
+			return tier[0], nil
+		}
+	}
+	return "", ErrNoTracker
+}
+
+//AnnounceList returns the tiers of tracker urls from the announce-list
+//(BEP 12). The outer slice holds the tiers in order of preference.
+//Torrents without an announce-list yield a single tier holding announce.
+func (mi *MetaInfo) AnnounceList() ([][]string, error) {
+	o, ok := mi.parsed["announce-list"]
+	if !ok {
+		if announce, ok := mi.parsed["announce"].(string); ok && announce != "" {
+			return [][]string{{announce}}, nil
+		}
+		return nil, nil
+	}
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("announce-list is not a list")
+	}
+	tiers := make([][]string, 0, len(list))
+	for _, t := range list {
+		tl, ok := t.([]interface{})
+		if !ok {
+			return nil, errors.New("announce-list tier is not a list")
+		}
+		tier := make([]string, 0, len(tl))
+		for _, u := range tl {
+			s, ok := u.(string)
+			if !ok {
+				return nil, errors.New("announce-list entry is not a string")
+			}
+			tier = append(tier, s)
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+//ShuffledAnnounceList returns the announce-list with the trackers of
+//each tier shuffled using rng, as BEP 12 asks clients to do on startup.
+//The order of the tiers is kept. It returns nil if the announce-list
+//is malformed.
+func (mi *MetaInfo) ShuffledAnnounceList(rng *rand.Rand) [][]string {
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return nil
+	}
+	for _, tier := range tiers {
+		rng.Shuffle(len(tier), func(i, j int) { tier[i], tier[j] = tier[j], tier[i] })
+	}
+	return tiers
+}
+
+//the parsed info dict
+func (mi *MetaInfo) infoDict() (map[string]interface{}, error) {
+	info, ok := mi.parsed["info"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Torrent has no info dict")
+	}
+	return info, nil
+}
+
+//TotalLength returns the number of bytes of content described by the
+//torrent, that is info.length or the sum of all file lengths.
+//It fails if the number of pieces doesn't match the length.
+func (mi *MetaInfo) TotalLength() (int64, error) {
+	info, err := mi.infoDict()
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	if l, ok := info["length"].(int64); ok {
+		total = l
+	} else if files, ok := info["files"].([]interface{}); ok {
+		for _, o := range files {
+			f, ok := o.(map[string]interface{})
+			if !ok {
+				return 0, errors.New("info.files entry is not a dict")
+			}
+			l, ok := f["length"].(int64)
+			if !ok || l < 0 {
+				return 0, errors.New("info.files entry has no valid length")
+			}
+			total += l
+		}
+	} else {
+		return 0, errors.New("info has neither length nor files")
+	}
+	if total < 0 {
+		return 0, errors.New("info.length is negative")
+	}
+
+	pl, ok := info["piece length"].(int64)
+	if !ok || pl <= 0 {
+		return 0, errors.New("info.piece length is missing or not a positive integer")
+	}
+	pieces, _ := info["pieces"].(string)
+	if n, exp := int64(len(pieces)/20), (total+pl-1)/pl; n != exp {
+		return 0, fmt.Errorf("Torrent has %d pieces but its length requires %d", n, exp)
+	}
+	return total, nil
+}
+
+//SelfCheck checks the structure of the torrent without looking at any
+//content: the number of pieces must match the total length and piece
+//length, and every file path of a multi-file torrent must be a non-empty
+//relative path that stays inside the torrent's directory.
+func (mi *MetaInfo) SelfCheck() error {
+	if _, err := mi.TotalLength(); err != nil {
+		return err
+	}
+	info, _ := mi.infoDict()
+	if _, ok := info["files"]; !ok {
+		return nil
+	}
+	for _, paths := range []func() ([][]string, error){mi.RawFilePaths, mi.FilePaths} {
+		list, err := paths()
+		if err != nil {
+			return err
+		}
+		for _, path := range list {
+			if err := checkPath(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//ErrUnsafePath is returned for a file path that is empty or could point
+//outside the torrent's directory, such as one containing "..".
+var ErrUnsafePath = errors.New("Unsafe file path")
+
+//check that path is a non-empty relative path without traversal
+func checkPath(path []string) error {
+	if len(path) == 0 {
+		return fmt.Errorf("%w: empty path", ErrUnsafePath)
+	}
+	for _, c := range path {
+		switch {
+		case c == "":
+			return fmt.Errorf("%w: empty component in %q", ErrUnsafePath, path)
+		case c == "." || c == "..":
+			return fmt.Errorf("%w: relative component in %q", ErrUnsafePath, path)
+		case strings.ContainsAny(c, "/\\"):
+			return fmt.Errorf("%w: separator in component %q", ErrUnsafePath, c)
+		}
+	}
+	return nil
+}
+
+//File is a file of a torrent.
+type File struct {
+	Path   []string //path components below the torrent's directory
+	Length int64
+}
+
+//Files returns the files of the torrent in the order of info.files, with
+//paths as returned by FilePaths. A single-file torrent yields one file
+//named Name. A path that's unsafe to use on disk fails with an error
+//matching ErrUnsafePath which names the offending entry.
+func (mi *MetaInfo) Files() ([]File, error) {
+	paths, err := mi.FilePaths()
+	if err != nil {
+		return nil, err
+	}
+	info, _ := mi.infoDict()
+	var lengths []int64
+	if l, ok := info["length"].(int64); ok {
+		lengths = []int64{l}
+	} else if files, ok := info["files"].([]interface{}); ok {
+		for _, f := range files {
+			l, ok := f.(map[string]interface{})["length"].(int64)
+			if !ok || l < 0 {
+				return nil, errors.New("info.files entry has no valid length")
+			}
+			lengths = append(lengths, l)
+		}
+	} else {
+		return nil, errors.New("info has neither length nor files")
+	}
+
+	files := make([]File, len(paths))
+	for i, path := range paths {
+		if err := checkPath(path); err != nil {
+			return nil, fmt.Errorf("File %d: %w", i, err)
+		}
+		files[i] = File{Path: path, Length: lengths[i]}
+	}
+	return files, nil
+}
+
+//VerifyPiece reports whether data is the piece with the given index, by
+//comparing its sha1 hash with the one stored in the torrent.
+//data must have the torrent's piece length, except for the last piece
+//which may be shorter.
+func (mi *MetaInfo) VerifyPiece(index int, data []byte) (bool, error) {
+	exp, err := mi.PieceSize(index)
+	if err != nil {
+		return false, err
+	}
+	info, _ := mi.infoDict()
+	pieces := info["pieces"].(string)
+	if int64(len(data)) != exp {
+		return false, fmt.Errorf("Piece %d has length %d, expected %d", index, len(data), exp)
+	}
+
+	h := sha1.Sum(data)
+	return string(h[:]) == pieces[index*20:(index+1)*20], nil
+}
+
+//VerifyFiles checks the content at root against the piece hashes of the
+//torrent, the recheck of BitTorrent clients. root is the content itself:
+//the file of a single-file torrent or the directory of a multi-file
+//torrent. Pieces are read from disk one at a time, across file boundaries.
+//A piece is missing if part of it is in a file that doesn't exist or is
+//too short, and corrupt if its hash doesn't match. Other I/O errors fail.
+func (mi *MetaInfo) VerifyFiles(root string) (missing []int, corrupt []int, err error) {
+	files, err := mi.Files()
+	if err != nil {
+		return nil, nil, err
+	}
+	total, pl, pieces, err := mi.pieceLayout()
+	if err != nil {
+		return nil, nil, err
+	}
+	ranges, _ := mi.FileOffsets()
+	info, _ := mi.infoDict()
+	_, single := info["length"]
+
+	//files are opened when first needed, nil if they don't exist
+	handles := make([]*os.File, len(files))
+	opened := make([]bool, len(files))
+	defer func() {
+		for _, f := range handles {
+			if f != nil {
+				f.Close()
+			}
+		}
+	}()
+	open := func(i int) (*os.File, error) {
+		if !opened[i] {
+			opened[i] = true
+			path := root
+			if !single {
+				path = filepath.Join(root, filepath.Join(files[i].Path...))
+			}
+			f, err := os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			handles[i] = f
+		}
+		return handles[i], nil
+	}
+
+	//the piece length comes from the torrent, it may be far larger than
+	//the content
+	bufLen := pl
+	if total < bufLen {
+		bufLen = total
+	}
+	buf := make([]byte, bufLen)
+	next := 0 //first file that may overlap the current piece
+	n := len(pieces) / 20
+	for p := 0; p < n; p++ {
+		size := pieceSize(p, n, total, pl)
+		start, end := int64(p)*pl, int64(p)*pl+size
+		data := buf[:size]
+		for next < len(ranges) && ranges[next].End <= start {
+			next++
+		}
+		present := true
+		for i := next; present && i < len(ranges) && ranges[i].Start < end; i++ {
+			r := ranges[i]
+			if r.Start == r.End {
+				continue
+			}
+			lo, hi := start, end
+			if r.Start > lo {
+				lo = r.Start
+			}
+			if r.End < hi {
+				hi = r.End
+			}
+			f, err := open(i)
+			if err != nil {
+				return nil, nil, err
+			}
+			if f == nil {
+				present = false
+			} else if _, err = f.ReadAt(data[lo-start:hi-start], lo-r.Start); err == io.EOF {
+				present = false
+			} else if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if !present {
+			missing = append(missing, p)
+		} else if h := sha1.Sum(data); string(h[:]) != pieces[p*20:p*20+20] {
+			corrupt = append(corrupt, p)
+		}
+	}
+	return missing, corrupt, nil
+}
+
+//PieceSize returns the length of the piece with the given index. All pieces
+//have the torrent's piece length, except for the last one which holds the
+//remainder of the content.
+func (mi *MetaInfo) PieceSize(index int) (int64, error) {
+	total, pl, pieces, err := mi.pieceLayout()
+	if err != nil {
+		return 0, err
+	}
+	n := len(pieces) / 20
+	if index < 0 || index >= n {
+		return 0, fmt.Errorf("Piece index %d out of range [0, %d)", index, n)
+	}
+	return pieceSize(index, n, total, pl), nil
+}
+
+//the total length, piece length and piece hashes of the torrent, as
+//checked by TotalLength
+func (mi *MetaInfo) pieceLayout() (total, pl int64, pieces string, err error) {
+	if total, err = mi.TotalLength(); err != nil {
+		return
+	}
+	info, _ := mi.infoDict()
+	pl = info["piece length"].(int64)
+	var ok bool
+	if pieces, ok = info["pieces"].(string); !ok {
+		err = errors.New("info.pieces is not a string")
+	}
+	return
+}
+
+//the size of piece index of the n pieces of total bytes
+func pieceSize(index, n int, total, pl int64) int64 {
+	if index == n-1 {
+		return total - pl*int64(n-1)
+	}
+	return pl
+}
+
+//BytesLeft returns the number of bytes of the pieces missing from have,
+//the left value of an announce. The last piece may be shorter than the
+//piece length. A nil bitfield means no piece is present.
+func (mi *MetaInfo) BytesLeft(have *Bitfield) (int64, error) {
+	total, pl, pieces, err := mi.pieceLayout()
+	if err != nil {
+		return 0, err
+	}
+	if have == nil {
+		return total, nil
+	}
+	n := len(pieces) / 20
+	if have.Len() != n {
+		return 0, fmt.Errorf("Bitfield has %d pieces, expected %d", have.Len(), n)
+	}
+
+	left := total
+	for i := 0; i < n; i++ {
+		if have.Has(i) {
+			left -= pieceSize(i, n, total, pl)
+		}
+	}
+	return left, nil
+}
+
+//IsPrivate reports whether the torrent is private (BEP 27), that is
+//info.private is the integer 1. Private torrents must not use DHT or PEX.
+func (mi *MetaInfo) IsPrivate() bool {
+	info, err := mi.infoDict()
+	if err != nil {
+		return false
+	}
+	p, ok := info["private"].(int64)
+	return ok && p == 1
+}
+
+//SetSource sets info.source, which private trackers use to give
+//otherwise identical content a distinct info hash for cross-seeding.
+//An empty source removes the key. Since the info dict changes, so does
+//the info hash.
+func (mi *MetaInfo) SetSource(source string) error {
+	info, err := mi.infoDict()
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		delete(info, "source")
+	} else {
+		info["source"] = source
+	}
+	mi.info = nil //the original info bytes are stale now
+	mi.key = ""
+	return nil
+}
+
+//SetDisplayName sets info.name, the suggested name of the torrent's file
+//or directory, and removes info.name.utf-8. Since the name is part of the
+//info dict this makes a different torrent: its info hash changes, and so
+//peers of the original swarm won't be found with it. name must be a
+//single safe path component.
+func (mi *MetaInfo) SetDisplayName(name string) error {
+	if err := checkPath([]string{name}); err != nil {
+		return err
+	}
+	info, err := mi.infoDict()
+	if err != nil {
+		return err
+	}
+	info["name"] = name
+	delete(info, "name.utf-8")
+	mi.info = nil //the original info bytes are stale now
+	mi.key = ""
+	return nil
+}
+
+//WebSeeds returns the urls of the web seeds (BEP 19) from url-list,
+//which may be a single string or a list of strings.
+func (mi *MetaInfo) WebSeeds() ([]string, error) {
+	switch v := mi.parsed["url-list"].(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		if v == "" {
+			return []string{}, nil
+		}
+		return []string{v}, nil
+	case []interface{}:
+		seeds := make([]string, 0, len(v))
+		for _, o := range v {
+			s, ok := o.(string)
+			if !ok {
+				return nil, errors.New("url-list entry is not a string")
+			}
+			seeds = append(seeds, s)
+		}
+		return seeds, nil
+	}
+	return nil, errors.New("url-list is neither a string nor a list")
+}
+
+//HTTPSeeds returns the urls of the HTTP seeds (BEP 17) from httpseeds,
+//a list of strings. These are distinct from the web seeds of url-list.
+func (mi *MetaInfo) HTTPSeeds() ([]string, error) {
+	o, ok := mi.parsed["httpseeds"]
+	if !ok {
+		return []string{}, nil
+	}
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("httpseeds is not a list")
+	}
+	seeds := make([]string, 0, len(list))
+	for _, o := range list {
+		s, ok := o.(string)
+		if !ok {
+			return nil, errors.New("httpseeds entry is not a string")
+		}
+		seeds = append(seeds, s)
+	}
+	return seeds, nil
+}
+
+//the BEP 38 key k, from the info dict or else the root of the torrent
+func (mi *MetaInfo) bep38(k string) (interface{}, bool) {
+	if info, err := mi.infoDict(); err == nil {
+		if o, ok := info[k]; ok {
+			return o, true
+		}
+	}
+	o, ok := mi.parsed[k]
+	return o, ok
+}
+
+//SimilarTorrents returns the info hashes of torrents sharing files with
+//this one (BEP 38) from similar, a list of 20 byte strings. The key is
+//looked up in the info dict and then at the root of the torrent.
+func (mi *MetaInfo) SimilarTorrents() ([][20]byte, error) {
+	o, ok := mi.bep38("similar")
+	if !ok {
+		return [][20]byte{}, nil
+	}
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("similar is not a list")
+	}
+	hashes := make([][20]byte, len(list))
+	for i, o := range list {
+		s, ok := o.(string)
+		if !ok || len(s) != 20 {
+			return nil, fmt.Errorf("similar entry %d is not an info hash", i)
+		}
+		copy(hashes[i][:], s)
+	}
+	return hashes, nil
+}
+
+//Collections returns the names of the collections the torrent belongs to
+//(BEP 38) from collections, a list of strings. The key is looked up in
+//the info dict and then at the root of the torrent.
+func (mi *MetaInfo) Collections() ([]string, error) {
+	o, ok := mi.bep38("collections")
+	if !ok {
+		return []string{}, nil
+	}
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("collections is not a list")
+	}
+	names := make([]string, 0, len(list))
+	for _, o := range list {
+		s, ok := o.(string)
+		if !ok {
+			return nil, errors.New("collections entry is not a string")
+		}
+		names = append(names, s)
+	}
+	return names, nil
+}
+
+//A DHTNode is a DHT node to bootstrap from.
+type DHTNode struct {
+	Host string //host name or IP address
+	Port int
+}
+
+//DHTNodes returns the DHT bootstrap nodes of a trackerless torrent (BEP 5)
+//from nodes, a list of [host, port] pairs.
+func (mi *MetaInfo) DHTNodes() ([]DHTNode, error) {
+	o, ok := mi.parsed["nodes"]
+	if !ok {
+		return []DHTNode{}, nil
+	}
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("nodes is not a list")
+	}
+	nodes := make([]DHTNode, 0, len(list))
+	for i, o := range list {
+		pair, ok := o.([]interface{})
+		if !ok || len(pair) != 2 {
+			return nil, fmt.Errorf("nodes entry %d is not a [host, port] pair", i)
+		}
+		host, ok := pair[0].(string)
+		if !ok || host == "" {
+			return nil, fmt.Errorf("nodes entry %d has an invalid host", i)
+		}
+		port, ok := pair[1].(int64)
+		if !ok || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("nodes entry %d has an invalid port", i)
+		}
+		nodes = append(nodes, DHTNode{Host: host, Port: int(port)})
+	}
+	return nodes, nil
+}
+
+//Name returns the suggested name of the torrent's file or directory:
+//info.name.utf-8 if it is present and valid UTF-8, info.name otherwise.
+func (mi *MetaInfo) Name() string {
+	info, err := mi.infoDict()
+	if err != nil {
+		return ""
+	}
+	if name, ok := info["name.utf-8"].(string); ok && utf8.ValidString(name) {
+		return name
+	}
+	return mi.RawName()
+}
+
+//RawName returns info.name as it is, possibly in a legacy encoding.
+func (mi *MetaInfo) RawName() string {
+	info, err := mi.infoDict()
+	if err != nil {
+		return ""
+	}
+	name, _ := info["name"].(string)
+	return name
+}
+
+//Publisher returns the publisher of the torrent: publisher.utf-8 if it is
+//present and valid UTF-8, publisher otherwise. It is "" if there is none.
+func (mi *MetaInfo) Publisher() string {
+	if p, ok := mi.parsed["publisher.utf-8"].(string); ok && utf8.ValidString(p) {
+		return p
+	}
+	p, _ := mi.parsed["publisher"].(string)
+	return p
+}
+
+//PublisherURL returns publisher-url, or "" if there is none.
+func (mi *MetaInfo) PublisherURL() string {
+	u, _ := mi.parsed["publisher-url"].(string)
+	return u
+}
+
+//CreationDate returns the creation date of the torrent, or the zero time
+//if it has none.
+func (mi *MetaInfo) CreationDate() time.Time {
+	if d, ok := mi.parsed["creation date"].(int64); ok {
+		return time.Unix(d, 0)
+	}
+	return time.Time{}
+}
+
+//FilePaths returns the path components of every file of a multi-file
+//torrent in the order of info.files. Like Name, a file's path.utf-8 is
+//preferred over its path if it is valid UTF-8. Single-file torrents
+//yield one path holding Name.
+func (mi *MetaInfo) FilePaths() ([][]string, error) {
+	return mi.filePaths(true)
+}
+
+//RawFilePaths is like FilePaths but always returns the original path
+//of every file.
+func (mi *MetaInfo) RawFilePaths() ([][]string, error) {
+	return mi.filePaths(false)
+}
+
+func (mi *MetaInfo) filePaths(preferUTF8 bool) ([][]string, error) {
+	info, err := mi.infoDict()
+	if err != nil {
+		return nil, err
+	}
+	files, ok := info["files"].([]interface{})
+	if !ok {
+		if preferUTF8 {
+			return [][]string{{mi.Name()}}, nil
+		}
+		return [][]string{{mi.RawName()}}, nil
+	}
+	paths := make([][]string, 0, len(files))
+	for _, f := range files {
+		d, ok := f.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("File entry is not a dict")
+		}
+		path, err := pathComponents(d["path"])
+		if err != nil {
+			return nil, err
+		}
+		if preferUTF8 {
+			if p, err := pathComponents(d["path.utf-8"]); err == nil && validUTF8(p) {
+				path = p
+			}
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+//convert a bencoded path list
+func pathComponents(o interface{}) ([]string, error) {
+	list, ok := o.([]interface{})
+	if !ok {
+		return nil, errors.New("File path is not a list")
+	}
+	path := make([]string, 0, len(list))
+	for _, c := range list {
+		s, ok := c.(string)
+		if !ok {
+			return nil, errors.New("File path component is not a string")
+		}
+		path = append(path, s)
+	}
+	return path, nil
+}
+
+func validUTF8(path []string) bool {
+	for _, c := range path {
+		if !utf8.ValidString(c) {
+			return false
+		}
+	}
+	return true
+}
+
+//FileRange locates a file within the concatenated content of a torrent.
+type FileRange struct {
+	Start      int64 //offset of the first byte
+	End        int64 //offset after the last byte
+	FirstPiece int   //index of the piece holding the first byte
+	LastPiece  int   //index of the piece holding the last byte, FirstPiece-1 for empty files
+}
+
+//FileOffsets returns the position of every file of the torrent within the
+//piece space, in the order of info.files. Single-file torrents yield one
+//range covering the whole content.
+func (mi *MetaInfo) FileOffsets() ([]FileRange, error) {
+	if _, err := mi.TotalLength(); err != nil {
+		return nil, err
+	}
+	info, _ := mi.infoDict()
+	pl := info["piece length"].(int64)
+
+	var lengths []int64
+	if l, ok := info["length"].(int64); ok {
+		lengths = []int64{l}
+	} else {
+		for _, f := range info["files"].([]interface{}) {
+			lengths = append(lengths, f.(map[string]interface{})["length"].(int64))
+		}
+	}
+
+	ranges := make([]FileRange, len(lengths))
+	var off int64
+	for i, l := range lengths {
+		r := FileRange{Start: off, End: off + l}
+		r.FirstPiece = int(off / pl)
+		r.LastPiece = int((off + l - 1) / pl)
+		if l == 0 {
+			r.LastPiece = r.FirstPiece - 1
+		}
+		ranges[i] = r
+		off += l
+	}
+	return ranges, nil
+}
+
+//PiecesForFile returns the indices of the pieces holding any part of the
+//file with the given index in the order of info.files (see FileOffsets).
+//A piece spanning a file boundary belongs to both files. Empty files
+//yield no pieces.
+func (mi *MetaInfo) PiecesForFile(fileIndex int) ([]int, error) {
+	ranges, err := mi.FileOffsets()
+	if err != nil {
+		return nil, err
+	}
+	if fileIndex < 0 || fileIndex >= len(ranges) {
+		return nil, fmt.Errorf("File index %d out of range", fileIndex)
+	}
+	r := ranges[fileIndex]
+	pieces := make([]int, 0, r.LastPiece-r.FirstPiece+1)
+	for i := r.FirstPiece; i <= r.LastPiece; i++ {
+		pieces = append(pieces, i)
+	}
+	return pieces, nil
+}
+
+//SameContent reports whether both torrents have the same info hash,
+//i.e. describe the same content regardless of trackers and comments.
+func (mi *MetaInfo) SameContent(other *MetaInfo) (bool, error) {
+	a, err := mi.InfoHash()
+	if err != nil {
+		return false, err
+	}
+	b, err := other.InfoHash()
+	if err != nil {
+		return false, err
+	}
+	return string(a) == string(b), nil
+}
+
+//Equal reports whether both torrents hold exactly the same metainfo.
+func (mi *MetaInfo) Equal(other *MetaInfo) bool {
+	return reflect.DeepEqual(mi.parsed, other.parsed)
+}
+
+//StripMetadata returns a new torrent holding only the info dict of mi,
+//without trackers, comment, creation date or other keys. The info hash
+//is unchanged. The info dict itself is copied, so e.g. SetSource on the
+//result doesn't affect mi. It returns nil if mi has no info dict.
+func (mi *MetaInfo) StripMetadata() *MetaInfo {
+	info, err := mi.infoDict()
+	if err != nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(info))
+	for k, v := range info {
+		copied[k] = v
+	}
+	return &MetaInfo{info: mi.info, parsed: map[string]interface{}{"info": copied}}
+}
+
+//MetaVersion returns the version of the metainfo format: 2 if
+//info.meta version is 2 (BEP 52), 1 otherwise.
+func (mi *MetaInfo) MetaVersion() int {
+	info, err := mi.infoDict()
+	if err != nil {
+		return 1
+	}
+	if v, ok := info["meta version"].(int64); ok && v == 2 {
+		return 2
+	}
+	return 1
+}
+
+//IsHybrid reports whether the torrent carries both v1 and v2 metadata,
+//so it can be shared in v1 and v2 swarms.
+func (mi *MetaInfo) IsHybrid() bool {
+	if mi.MetaVersion() != 2 {
+		return false
+	}
+	info, _ := mi.infoDict()
+	_, ok := info["pieces"]
+	return ok
+}
+
+//PieceLayers returns the piece layers of a v2 torrent (BEP 52): for each
+//file larger than the piece length, its pieces root mapped to the
+//concatenated 32 byte hashes of its pieces. A v1 torrent has none.
+func (mi *MetaInfo) PieceLayers() (map[string][]byte, error) {
+	o, ok := mi.parsed["piece layers"]
+	if !ok {
+		return map[string][]byte{}, nil
+	}
+	d, ok := o.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("piece layers is not a dict")
+	}
+	layers := make(map[string][]byte, len(d))
+	for root, o := range d {
+		hashes, ok := o.(string)
+		if len(root) != 32 || !ok || len(hashes) == 0 || len(hashes)%32 != 0 {
+			return nil, fmt.Errorf("Invalid piece layer for pieces root %x", root)
+		}
+		layers[root] = []byte(hashes)
+	}
+	return layers, nil
+}
+
+//AddTracker adds url to the given tier of the announce-list. A tier past
+//the last one appends a new tier. The info dict is left alone, so the info
+//hash doesn't change. It fails if the torrent already has the tracker.
+func (mi *MetaInfo) AddTracker(url string, tier int) error {
+	if tier < 0 {
+		return errors.New("Negative tracker tier")
+	}
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return err
+	}
+	for _, t := range tiers {
+		for _, u := range t {
+			if u == url {
+				return errors.New("Torrent already has tracker " + url)
+			}
+		}
+	}
+	if tier < len(tiers) {
+		tiers[tier] = append(tiers[tier], url)
+	} else {
+		tiers = append(tiers, []string{url})
+	}
+
+	mi.setAnnounceList(tiers)
+	if _, ok := mi.parsed["announce"]; !ok {
+		mi.parsed["announce"] = url
+	}
+	return nil
+}
+
+//PromoteTracker moves url to the front of its tier of the announce-list,
+//as BEP 12 asks clients to do after a successful announce. The other
+//trackers and tiers keep their order and the info hash doesn't change.
+//It fails if the torrent doesn't have the tracker.
+func (mi *MetaInfo) PromoteTracker(url string) error {
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return err
+	}
+	for _, t := range tiers {
+		for i, u := range t {
+			if u != url {
+				continue
+			}
+			if i == 0 {
+				return nil
+			}
+			copy(t[1:i+1], t[:i])
+			t[0] = url
+			mi.setAnnounceList(tiers)
+			return nil
+		}
+	}
+	return errors.New("Torrent doesn't have tracker " + url)
+}
+
+//store tiers as the announce-list
+func (mi *MetaInfo) setAnnounceList(tiers [][]string) {
+	list := make([]interface{}, len(tiers))
+	for i, t := range tiers {
+		tier := make([]interface{}, len(t))
+		for j, u := range t {
+			tier[j] = u
+		}
+		list[i] = tier
+	}
+	if mi.parsed == nil {
+		mi.parsed = make(map[string]interface{})
+	}
+	mi.parsed["announce-list"] = list
 }
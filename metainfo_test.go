@@ -0,0 +1,1364 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gorrent/bencode"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//write a torrent to a temporary file and read it back
+func readTestTorrent(t *testing.T, data string) *MetaInfo {
+	filename := filepath.Join(t.TempDir(), "test.torrent")
+	if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile(filename); err != nil {
+		t.Fatalf("Couldn't read torrent: %v", err)
+	}
+	return mi
+}
+
+//bencode an object, failing the test on error
+func mustEncode(t *testing.T, o interface{}) []byte {
+	b, err := bencode.Encode(o)
+	if err != nil {
+		t.Fatalf("Couldn't encode %#v: %v", o, err)
+	}
+	return b
+}
+
+//bencode a torrent and read it back through a temporary file
+func readTestTorrentDict(t *testing.T, d map[string]interface{}) *MetaInfo {
+	return readTestTorrent(t, string(mustEncode(t, d)))
+}
+
+//a new minimal single-file info dict, for tests that don't care about it
+func testInfo() map[string]interface{} {
+	return map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+}
+
+//the info hash of mi, failing the test if there is none
+func mustInfoHash(t *testing.T, mi *MetaInfo) []byte {
+	t.Helper()
+	h, err := mi.InfoHash()
+	if err != nil {
+		t.Fatalf("InfoHash: %v", err)
+	}
+	return h
+}
+
+func TestInfoHashEmptyInfo(t *testing.T) {
+	mi := &MetaInfo{}
+	if _, err := mi.ReadFrom(strings.NewReader("d8:announce8:http://a4:infodee")); err == nil {
+		t.Errorf("ReadFrom: expected error for empty info dict")
+	}
+	if h, err := mi.InfoHash(); err == nil {
+		t.Errorf("InfoHash: expected error for empty info dict, got %x", h)
+	}
+	if h := mi.InfoHashHex(); h != "" {
+		t.Errorf("InfoHashHex: expected no hash for empty info dict, got %s", h)
+	}
+	mi = &MetaInfo{parsed: map[string]interface{}{"announce": "http://a"}}
+	if h, err := mi.InfoHash(); err == nil {
+		t.Errorf("InfoHash: expected error for missing info dict, got %x", h)
+	}
+}
+
+func TestInfoHashWith(t *testing.T) {
+	mi := readTestTorrent(t, "d4:infod6:lengthi5e4:name8:test.txt12:piece lengthi16384e6:pieces20:"+strings.Repeat("x", 20)+"ee")
+	b, _ := mi.InfoBytes()
+	v1, err1 := mi.InfoHashWith(nil)
+	v2, err2 := mi.InfoHashWith(sha256.New)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("InfoHashWith: %v, %v", err1, err2)
+	}
+	if len(v1) != 20 || len(v2) != 32 {
+		t.Errorf("InfoHashWith: expected 20 and 32 bytes, got %d and %d", len(v1), len(v2))
+	}
+	if !bytes.Equal(v1, mustInfoHash(t, mi)) {
+		t.Errorf("InfoHashWith: default hash differs from InfoHash")
+	}
+	if exp := sha256.Sum256(b); !bytes.Equal(v2, exp[:]) {
+		t.Errorf("InfoHashWith: expected sha256 %x, got %x", exp, v2)
+	}
+	if h, err := (&MetaInfo{}).InfoHashWith(sha256.New); err == nil {
+		t.Errorf("InfoHashWith: expected error without info dict, got %x", h)
+	}
+}
+
+func TestInfoHashUnsortedKeys(t *testing.T) {
+	pieces := "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13"
+	info := "d4:name8:test.txt12:piece lengthi16384e6:lengthi5e6:pieces20:" + pieces + "e"
+	mi := readTestTorrent(t, "d8:announce20:http://tracker/annce4:info"+info+"e")
+
+	exp := "393a54264fee439a6f4730f2db601201448ec7b3"
+	if h := hex.EncodeToString(mustInfoHash(t, mi)); h != exp {
+		t.Errorf("InfoHash: expected %s, got %s", exp, h)
+	}
+}
+
+func TestDuplicateInfoDict(t *testing.T) {
+	pieces := strings.Repeat("x", 20)
+	good := "d6:lengthi5e4:name4:good12:piece lengthi16384e6:pieces20:" + pieces + "e"
+	evil := "d6:lengthi5e4:name4:evil12:piece lengthi16384e6:pieces20:" + pieces + "e"
+	mi := &MetaInfo{}
+	if _, err := mi.ReadFrom(strings.NewReader("d4:info" + good + "4:info" + evil + "e")); !errors.Is(err, bencode.ErrorDuplicateKey) {
+		t.Errorf("ReadFrom: expected ErrorDuplicateKey for two info dicts, got %v", err)
+	}
+	dup := "d6:lengthi5e4:name4:good4:name4:evil12:piece lengthi16384e6:pieces20:" + pieces + "e"
+	if _, err := mi.ReadFrom(strings.NewReader("d4:info" + dup + "e")); !errors.Is(err, bencode.ErrorDuplicateKey) {
+		t.Errorf("ReadFrom: expected ErrorDuplicateKey for two names, got %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "dup.torrent")
+	if err := ioutil.WriteFile(filename, []byte("d4:info"+good+"4:info"+evil+"e"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := mi.ReadFromFile(filename); !errors.Is(err, bencode.ErrorDuplicateKey) {
+		t.Errorf("ReadFromFile: expected ErrorDuplicateKey for two info dicts, got %v", err)
+	}
+	if _, err := mi.ReadFrom(strings.NewReader("d4:info" + good + "e\n")); err != nil {
+		t.Errorf("ReadFrom: trailing newline rejected: %v", err)
+	}
+}
+
+func TestInfoHashEncodings(t *testing.T) {
+	pieces := "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13"
+	info := "d4:name8:test.txt12:piece lengthi16384e6:lengthi5e6:pieces20:" + pieces + "e"
+	mi := readTestTorrent(t, "d8:announce20:http://tracker/annce4:info"+info+"e")
+
+	if h, exp := mi.InfoHashHex(), "393a54264fee439a6f4730f2db601201448ec7b3"; h != exp {
+		t.Errorf("InfoHashHex: expected %s, got %s", exp, h)
+	}
+	if h, exp := mi.InfoHashBase32(), "HE5FIJSP5ZBZU32HGDZNWYASAFCI5R5T"; h != exp {
+		t.Errorf("InfoHashBase32: expected %s, got %s", exp, h)
+	}
+	if h := new(MetaInfo).InfoHashBase32(); h != "" {
+		t.Errorf("InfoHashBase32: expected empty string without info dict, got %s", h)
+	}
+}
+
+func TestKey(t *testing.T) {
+	a, b := &MetaInfo{}, &MetaInfo{}
+	if err := a.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	b.parsed["comment"] = "same content, other comment"
+	if a.Key() != b.Key() || a.Key() != a.InfoHashHex() {
+		t.Errorf("Key: expected %s for both, got %s and %s", a.InfoHashHex(), a.Key(), b.Key())
+	}
+
+	keys := map[string]*MetaInfo{a.Key(): a}
+	if err := b.SetSource("X"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys[b.Key()]; ok || b.Key() != b.InfoHashHex() {
+		t.Errorf("Key: expected a new key after SetSource, got %s", b.Key())
+	}
+	if new(MetaInfo).Key() != "" {
+		t.Errorf("Key: expected empty key without info dict")
+	}
+}
+
+func TestWriteToFile(t *testing.T) {
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "out.torrent")
+	if err := mi.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	mi2 := &MetaInfo{}
+	if err := mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if h1, h2 := hex.EncodeToString(mustInfoHash(t, mi)), hex.EncodeToString(mustInfoHash(t, mi2)); h1 != h2 {
+		t.Errorf("WriteToFile: info hash changed from %s to %s", h1, h2)
+	}
+
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("WriteToFile: expected mode 0644 for new file, got %v (%v)", fi.Mode(), err)
+	}
+	if err := os.Chmod(filename, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := mi.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("WriteToFile: expected mode of the replaced file 0600, got %v (%v)", fi.Mode(), err)
+	}
+
+	if err := new(MetaInfo).WriteToFile(filename); err == nil {
+		t.Errorf("WriteToFile: expected error for empty metainfo")
+	}
+}
+
+func TestWriteToFileUnsortedInfo(t *testing.T) {
+	pieces := "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13"
+	info := "d4:name8:test.txt12:piece lengthi16384e6:lengthi5e6:pieces20:" + pieces + "e"
+	mi := readTestTorrent(t, "d8:announce20:http://tracker/annce4:info"+info+"e")
+
+	filename := filepath.Join(t.TempDir(), "out.torrent")
+	if err := mi.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	mi2 := &MetaInfo{}
+	if err := mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if h, exp := mi2.InfoHashHex(), "393a54264fee439a6f4730f2db601201448ec7b3"; h != exp {
+		t.Errorf("WriteToFile: info hash changed from %s to %s", exp, h)
+	}
+	if b, _ := mi2.InfoBytes(); string(b) != info {
+		t.Errorf("WriteToFile: expected info dict %q, got %q", info, b)
+	}
+}
+
+func TestAnnounceList(t *testing.T) {
+	info := testInfo()
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce": "http://a/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a/announce", "http://b/announce"},
+			[]interface{}{"udp://c:80/announce"},
+		},
+		"info": info,
+	})
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		t.Fatalf("AnnounceList: %v", err)
+	}
+	exp := [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80/announce"}}
+	if !reflect.DeepEqual(tiers, exp) {
+		t.Errorf("AnnounceList: expected %v, got %v", exp, tiers)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	if tiers, err = mi.AnnounceList(); err != nil {
+		t.Fatalf("AnnounceList: %v", err)
+	}
+	if exp = [][]string{{"http://a/announce"}}; !reflect.DeepEqual(tiers, exp) {
+		t.Errorf("AnnounceList: expected %v, got %v", exp, tiers)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"announce-list": "http://a/announce", "info": info})
+	if _, err = mi.AnnounceList(); err == nil {
+		t.Errorf("AnnounceList: expected error for malformed announce-list")
+	}
+}
+
+func TestTrackerless(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"nodes": []interface{}{[]interface{}{"router.example", 6881}},
+		"info":  info,
+	})
+	if err := mi.Validate(); err != nil {
+		t.Errorf("Validate: trackerless torrent rejected: %v", err)
+	}
+	if _, err := mi.Announce(); !errors.Is(err, ErrNoTracker) {
+		t.Errorf("Announce: expected ErrNoTracker, got %v", err)
+	}
+	if tiers, err := mi.AnnounceList(); err != nil || tiers != nil {
+		t.Errorf("AnnounceList: expected no tiers, got %v (%v)", tiers, err)
+	}
+	if _, err := mi.InfoHash(); err != nil {
+		t.Errorf("InfoHash: no hash for trackerless torrent: %v", err)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{
+		"announce-list": []interface{}{[]interface{}{"http://b/announce"}},
+		"info":          info,
+	})
+	if a, err := mi.Announce(); err != nil || a != "http://b/announce" {
+		t.Errorf("Announce: expected http://b/announce from the announce-list, got %s (%v)", a, err)
+	}
+	mi = readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	if a, err := mi.Announce(); err != nil || a != "http://a/announce" {
+		t.Errorf("Announce: expected http://a/announce, got %s (%v)", a, err)
+	}
+}
+
+func TestShuffledAnnounceList(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	var first, second []interface{}
+	for i := 0; i < 8; i++ {
+		first = append(first, fmt.Sprintf("http://a%d/announce", i))
+	}
+	second = []interface{}{"http://b/announce", "http://c/announce"}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce-list": []interface{}{first, second, []interface{}{"udp://d:80"}},
+		"info":          info,
+	})
+	orig, _ := mi.AnnounceList()
+
+	tiers := mi.ShuffledAnnounceList(rand.New(rand.NewSource(1)))
+	if again := mi.ShuffledAnnounceList(rand.New(rand.NewSource(1))); !reflect.DeepEqual(tiers, again) {
+		t.Errorf("ShuffledAnnounceList: same seed gave %v and %v", tiers, again)
+	}
+	if len(tiers) != len(orig) {
+		t.Fatalf("ShuffledAnnounceList: expected %d tiers, got %v", len(orig), tiers)
+	}
+	if reflect.DeepEqual(tiers[0], orig[0]) {
+		t.Errorf("ShuffledAnnounceList: first tier wasn't shuffled: %v", tiers[0])
+	}
+	for i := range tiers {
+		got := append([]string(nil), tiers[i]...)
+		sort.Strings(got)
+		exp := append([]string(nil), orig[i]...)
+		sort.Strings(exp)
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("ShuffledAnnounceList: tier %d has %v, expected the trackers %v", i, tiers[i], orig[i])
+		}
+	}
+	if again, _ := mi.AnnounceList(); !reflect.DeepEqual(again, orig) {
+		t.Errorf("ShuffledAnnounceList: modified the announce-list: %v", again)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"name":         "x",
+			"length":       int64(1),
+			"piece length": int64(16384),
+			"pieces":       strings.Repeat("x", 20),
+		}
+	}
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": valid()}}
+	if err := mi.Validate(); err != nil {
+		t.Errorf("Validate: unexpected error %v", err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		modify func(info map[string]interface{})
+		n      int //number of problems expected
+	}{
+		{"no name", func(info map[string]interface{}) { delete(info, "name") }, 1},
+		{"zero piece length", func(info map[string]interface{}) { info["piece length"] = int64(0) }, 1},
+		{"short pieces", func(info map[string]interface{}) { info["pieces"] = "abc" }, 1},
+		{"length and files", func(info map[string]interface{}) { info["files"] = []interface{}{} }, 1},
+		{"no length or files", func(info map[string]interface{}) { delete(info, "length") }, 1},
+		{"several", func(info map[string]interface{}) {
+			info["name"] = int64(5)
+			info["pieces"] = []interface{}{}
+			delete(info, "length")
+		}, 3},
+	} {
+		info := valid()
+		c.modify(info)
+		mi := &MetaInfo{parsed: map[string]interface{}{"info": info}}
+		err := mi.Validate()
+		if err == nil {
+			t.Errorf("Validate(%s): expected error", c.name)
+			continue
+		}
+		if n := len(strings.Split(err.Error(), "\n")); n != c.n {
+			t.Errorf("Validate(%s): expected %d problems, got %d: %v", c.name, c.n, n, err)
+		}
+	}
+
+	mi = &MetaInfo{parsed: map[string]interface{}{"info": "x"}}
+	if err := mi.Validate(); err == nil {
+		t.Errorf("Validate: expected error for non-dict info")
+	}
+	filename := filepath.Join(t.TempDir(), "bad.torrent")
+	ioutil.WriteFile(filename, []byte("d4:infod4:name1:xee"), 0644)
+	if err := new(MetaInfo).ReadFromFile(filename); err == nil {
+		t.Errorf("ReadFromFile: expected validation error")
+	}
+}
+
+func TestTotalLength(t *testing.T) {
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"length":       int64(40000),
+		"piece length": int64(16384),
+		"pieces":       strings.Repeat("x", 3*20),
+	}}}
+	if l, err := mi.TotalLength(); err != nil || l != 40000 {
+		t.Errorf("TotalLength: expected 40000, got %d (%v)", l, err)
+	}
+
+	mi = &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(10), "path": []interface{}{"a"}},
+			map[string]interface{}{"length": int64(30), "path": []interface{}{"b"}},
+		},
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 3*20),
+	}}}
+	if l, err := mi.TotalLength(); err != nil || l != 40 {
+		t.Errorf("TotalLength: expected 40, got %d (%v)", l, err)
+	}
+
+	mi.parsed["info"].(map[string]interface{})["pieces"] = strings.Repeat("x", 2*20)
+	if _, err := mi.TotalLength(); err == nil {
+		t.Errorf("TotalLength: expected error for mismatched piece count")
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mi.SelfCheck(); err != nil {
+		t.Errorf("SelfCheck: %v", err)
+	}
+
+	multi := func(pieces int, paths ...[]interface{}) *MetaInfo {
+		var files []interface{}
+		for _, p := range paths {
+			files = append(files, map[string]interface{}{"length": int64(10), "path": p})
+		}
+		return readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": int64(16),
+			"pieces":       strings.Repeat("x", 20*pieces),
+			"files":        files,
+		}})
+	}
+	if err := multi(2, []interface{}{"a"}, []interface{}{"sub", "b"}).SelfCheck(); err != nil {
+		t.Errorf("SelfCheck: %v", err)
+	}
+	if err := multi(3, []interface{}{"a"}, []interface{}{"b"}).SelfCheck(); err == nil {
+		t.Errorf("SelfCheck: expected error for wrong piece count")
+	}
+	for _, p := range [][]interface{}{
+		{"..", "etc", "passwd"},
+		{"sub", "..", "..", "x"},
+		{},
+		{"a", ""},
+		{"a/../../b"},
+	} {
+		if err := multi(2, []interface{}{"a"}, p).SelfCheck(); err == nil {
+			t.Errorf("SelfCheck: expected error for path %q", p)
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	multi := func(paths ...[]interface{}) *MetaInfo {
+		var files []interface{}
+		for _, p := range paths {
+			files = append(files, map[string]interface{}{"length": int64(10), "path": p})
+		}
+		return readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": int64(16),
+			"pieces":       strings.Repeat("x", 40),
+			"files":        files,
+		}})
+	}
+	files, err := multi([]interface{}{"a"}, []interface{}{"sub", "b"}).Files()
+	exp := []File{{[]string{"a"}, 10}, {[]string{"sub", "b"}, 10}}
+	if err != nil || !reflect.DeepEqual(files, exp) {
+		t.Errorf("Files: expected %v, got %v (%v)", exp, files, err)
+	}
+
+	_, err = multi([]interface{}{"a"}, []interface{}{"..", "etc", "passwd"}).Files()
+	if !errors.Is(err, ErrUnsafePath) || !strings.Contains(err.Error(), "File 1") {
+		t.Errorf("Files: expected ErrUnsafePath for file 1, got %v", err)
+	}
+	for _, p := range [][]interface{}{{"."}, {"a", "b/c"}, {"a\\b"}} {
+		if _, err = multi(p).Files(); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("Files: expected ErrUnsafePath for %q, got %v", p, err)
+		}
+	}
+
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := mi.TotalLength()
+	if files, err = mi.Files(); err != nil || len(files) != 1 || files[0].Path[0] != mi.Name() || files[0].Length != l {
+		t.Errorf("Files: unexpected files %v of single-file torrent (%v)", files, err)
+	}
+}
+
+func TestVerifyPiece(t *testing.T) {
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	var pieces string
+	for i := 0; i < len(data); i += 16 {
+		end := i + 16
+		if end > len(data) {
+			end = len(data)
+		}
+		h := sha1.Sum(data[i:end])
+		pieces += string(h[:])
+	}
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"name":         "x",
+		"length":       int64(len(data)),
+		"piece length": int64(16),
+		"pieces":       pieces,
+	}}}
+
+	if ok, err := mi.VerifyPiece(1, data[16:32]); err != nil || !ok {
+		t.Errorf("VerifyPiece: expected piece 1 to verify (%v)", err)
+	}
+	if ok, err := mi.VerifyPiece(2, data[32:]); err != nil || !ok {
+		t.Errorf("VerifyPiece: expected short last piece to verify (%v)", err)
+	}
+
+	corrupt := append([]byte(nil), data[:16]...)
+	corrupt[3] ^= 0xff
+	if ok, err := mi.VerifyPiece(0, corrupt); err != nil || ok {
+		t.Errorf("VerifyPiece: expected corrupted piece to fail (%v)", err)
+	}
+
+	if _, err := mi.VerifyPiece(3, data[:4]); err == nil {
+		t.Errorf("VerifyPiece: expected error for out of range index")
+	}
+	if _, err := mi.VerifyPiece(-1, data[:16]); err == nil {
+		t.Errorf("VerifyPiece: expected error for negative index")
+	}
+	if _, err := mi.VerifyPiece(0, data[:4]); err == nil {
+		t.Errorf("VerifyPiece: expected error for short piece")
+	}
+}
+
+func TestCreationDate(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	tm := time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC)
+	mi := readTestTorrentDict(t, map[string]interface{}{"creation date": tm, "info": info})
+	if b, _ := bencode.Encode(mi.parsed["creation date"]); string(b) != "i1257894000e" {
+		t.Errorf("CreationDate: expected encoding as integer seconds, got %q", b)
+	}
+	if d := mi.CreationDate(); !d.Equal(tm) {
+		t.Errorf("CreationDate: expected %v, got %v", tm, d)
+	}
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if d := mi.CreationDate(); !d.IsZero() {
+		t.Errorf("CreationDate: expected zero time without creation date, got %v", d)
+	}
+}
+
+func TestVerifyFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "content")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	//a: pieces 0-1, b: pieces 1-3, the last piece being short
+	write := func(name string, data string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", strings.Repeat("a", 20))
+	write("b", strings.Repeat("b", 30))
+	mi, err := CreateFromDir(dir, 16, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(expMissing, expCorrupt []int) {
+		t.Helper()
+		missing, corrupt, err := mi.VerifyFiles(dir)
+		if err != nil || !reflect.DeepEqual(missing, expMissing) || !reflect.DeepEqual(corrupt, expCorrupt) {
+			t.Errorf("VerifyFiles: expected missing %v and corrupt %v, got %v and %v (%v)", expMissing, expCorrupt, missing, corrupt, err)
+		}
+	}
+	check(nil, nil)
+
+	write("b", strings.Repeat("b", 20)+"x"+strings.Repeat("b", 9))
+	check(nil, []int{2})
+	write("b", strings.Repeat("b", 29))
+	check([]int{3}, nil)
+	os.Remove(filepath.Join(dir, "a"))
+	check([]int{0, 1, 3}, nil)
+
+	file := filepath.Join(dir, "b")
+	write("b", strings.Repeat("b", 30))
+	if mi, err = CreateFromFile(file, 16, ""); err != nil {
+		t.Fatal(err)
+	}
+	if missing, corrupt, err := mi.VerifyFiles(file); err != nil || missing != nil || corrupt != nil {
+		t.Errorf("VerifyFiles: expected single file to verify, got missing %v and corrupt %v (%v)", missing, corrupt, err)
+	}
+}
+
+func TestVerifyFilesHugePieceLength(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "x")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := sha1.Sum([]byte("x"))
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{
+		"name":         "x",
+		"length":       int64(1),
+		"piece length": int64(1) << 62,
+		"pieces":       string(h[:]),
+	}})
+	if missing, corrupt, err := mi.VerifyFiles(file); err != nil || missing != nil || corrupt != nil {
+		t.Errorf("VerifyFiles: expected file to verify, got missing %v and corrupt %v (%v)", missing, corrupt, err)
+	}
+}
+
+func TestPieceSize(t *testing.T) {
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{
+		"name":         "x",
+		"length":       int64(50),
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 4*20),
+	}})
+	for _, c := range []struct {
+		index int
+		size  int64
+	}{
+		{0, 16},
+		{1, 16},
+		{3, 2},
+	} {
+		if size, err := mi.PieceSize(c.index); err != nil || size != c.size {
+			t.Errorf("PieceSize(%d): expected %d, got %d (%v)", c.index, c.size, size, err)
+		}
+	}
+	for _, index := range []int{-1, 4} {
+		if _, err := mi.PieceSize(index); err == nil {
+			t.Errorf("PieceSize(%d): expected out of range error", index)
+		}
+	}
+}
+
+func TestPieceSizeInvalidPieces(t *testing.T) {
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"name":         "x",
+		"length":       int64(0),
+		"piece length": int64(16),
+		"pieces":       int64(1),
+	}}}
+	if _, err := mi.PieceSize(0); err == nil {
+		t.Errorf("PieceSize: expected error for pieces that aren't a string")
+	}
+	if _, err := mi.BytesLeft(NewBitfield(0)); err == nil {
+		t.Errorf("BytesLeft: expected error for pieces that aren't a string")
+	}
+	if _, _, err := mi.VerifyFiles(t.TempDir()); err == nil {
+		t.Errorf("VerifyFiles: expected error for pieces that aren't a string")
+	}
+}
+
+func TestBytesLeft(t *testing.T) {
+	//4 pieces of 16 bytes, the last one holding only 2
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{
+		"name":         "x",
+		"length":       int64(50),
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 4*20),
+	}})
+	have := NewBitfield(4)
+	for _, c := range []struct {
+		set  int
+		left int64
+	}{
+		{-1, 50},
+		{1, 34},
+		{3, 32},
+		{0, 16},
+		{2, 0},
+	} {
+		if c.set >= 0 {
+			have.Set(c.set)
+		}
+		if left, err := mi.BytesLeft(have); err != nil || left != c.left {
+			t.Errorf("BytesLeft: expected %d after setting piece %d, got %d (%v)", c.left, c.set, left, err)
+		}
+	}
+	if left, err := mi.BytesLeft(nil); err != nil || left != 50 {
+		t.Errorf("BytesLeft: expected 50 without bitfield, got %d (%v)", left, err)
+	}
+	if _, err := mi.BytesLeft(NewBitfield(5)); err == nil {
+		t.Errorf("BytesLeft: expected error for bitfield of wrong size")
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	for _, c := range []struct {
+		private interface{}
+		exp     bool
+	}{
+		{nil, false},
+		{int64(1), true},
+		{int64(0), false},
+		{int64(2), false},
+		{"1", false},
+	} {
+		info := map[string]interface{}{"name": "x"}
+		if c.private != nil {
+			info["private"] = c.private
+		}
+		mi := &MetaInfo{parsed: map[string]interface{}{"info": info}}
+		if p := mi.IsPrivate(); p != c.exp {
+			t.Errorf("IsPrivate(%#v): expected %v, got %v", c.private, c.exp, p)
+		}
+	}
+	if new(MetaInfo).IsPrivate() {
+		t.Errorf("IsPrivate: expected false without info dict")
+	}
+}
+
+func TestReadGzip(t *testing.T) {
+	orig := &MetaInfo{}
+	if err := orig.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(orig.raw)
+	zw.Close()
+	filename := filepath.Join(t.TempDir(), "test.torrent.gz")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile(filename); err != nil {
+		t.Fatalf("ReadFromFile: %v", err)
+	}
+	if !bytes.Equal(mustInfoHash(t, mi), mustInfoHash(t, orig)) || !reflect.DeepEqual(mi.parsed, orig.parsed) {
+		t.Errorf("ReadFromFile: gzipped torrent parsed differently")
+	}
+
+	mi = &MetaInfo{}
+	if _, err := mi.ReadFrom(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Errorf("ReadFrom: expected error for truncated gzip data")
+	}
+}
+
+func TestSetSource(t *testing.T) {
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	orig := hex.EncodeToString(mustInfoHash(t, mi))
+	if err := mi.SetSource("TRACKER"); err != nil {
+		t.Fatalf("SetSource: %v", err)
+	}
+	h := hex.EncodeToString(mustInfoHash(t, mi))
+	if h == orig {
+		t.Errorf("SetSource: info hash didn't change")
+	}
+
+	filename := filepath.Join(t.TempDir(), "out.torrent")
+	if err := mi.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	mi2 := &MetaInfo{}
+	if err := mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if h2 := hex.EncodeToString(mustInfoHash(t, mi2)); h2 != h {
+		t.Errorf("SetSource: expected info hash %s after re-reading, got %s", h, h2)
+	}
+
+	if err := mi2.SetSource(""); err != nil {
+		t.Fatalf("SetSource: %v", err)
+	}
+	if h2 := hex.EncodeToString(mustInfoHash(t, mi2)); h2 != orig {
+		t.Errorf("SetSource: expected original info hash %s after removing the source, got %s", orig, h2)
+	}
+
+	if err := new(MetaInfo).SetSource("TRACKER"); err == nil {
+		t.Errorf("SetSource: expected error without info dict")
+	}
+}
+
+func TestWebSeeds(t *testing.T) {
+	for _, c := range []struct {
+		urlList interface{}
+		exp     []string
+	}{
+		{nil, []string{}},
+		{"http://a/file", []string{"http://a/file"}},
+		{[]interface{}{"http://a/", "ftp://b/"}, []string{"http://a/", "ftp://b/"}},
+	} {
+		mi := &MetaInfo{parsed: map[string]interface{}{}}
+		if c.urlList != nil {
+			mi.parsed["url-list"] = c.urlList
+		}
+		seeds, err := mi.WebSeeds()
+		if err != nil || !reflect.DeepEqual(seeds, c.exp) {
+			t.Errorf("WebSeeds(%#v): expected %v, got %v (%v)", c.urlList, c.exp, seeds, err)
+		}
+	}
+
+	mi := &MetaInfo{parsed: map[string]interface{}{"url-list": int64(1)}}
+	if _, err := mi.WebSeeds(); err == nil {
+		t.Errorf("WebSeeds: expected error for malformed url-list")
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	mi.parsed["comment"] = "a comment"
+	mi.parsed["created by"] = "gorrent"
+	mi.parsed["creation date"] = int64(1300000000)
+
+	stripped := mi.StripMetadata()
+	if stripped == nil {
+		t.Fatal("StripMetadata: got nil")
+	}
+	if h1, h2 := mi.InfoHashHex(), stripped.InfoHashHex(); h1 != h2 {
+		t.Errorf("StripMetadata: info hash changed from %s to %s", h1, h2)
+	}
+	if len(stripped.parsed) != 1 || stripped.parsed["info"] == nil {
+		t.Errorf("StripMetadata: expected only the info dict, got keys %v", stripped.parsed)
+	}
+	if _, err := stripped.Announce(); !errors.Is(err, ErrNoTracker) {
+		t.Errorf("Announce: expected ErrNoTracker, got %v", err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "stripped.torrent")
+	if err := stripped.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	mi2 := &MetaInfo{}
+	if err := mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if h1, h2 := mi.InfoHashHex(), mi2.InfoHashHex(); h1 != h2 {
+		t.Errorf("StripMetadata: info hash changed from %s to %s after writing", h1, h2)
+	}
+
+	stripped.SetSource("X")
+	if info, _ := mi.infoDict(); info["source"] != nil {
+		t.Errorf("StripMetadata: SetSource on the copy changed the original")
+	}
+	if new(MetaInfo).StripMetadata() != nil {
+		t.Errorf("StripMetadata: expected nil without info dict")
+	}
+}
+
+func TestHTTPSeeds(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"httpseeds": []interface{}{"http://a/seed", "http://b/seed"},
+		"url-list":  "http://c/file",
+		"info":      info,
+	})
+	seeds, err := mi.HTTPSeeds()
+	if exp := []string{"http://a/seed", "http://b/seed"}; err != nil || !reflect.DeepEqual(seeds, exp) {
+		t.Errorf("HTTPSeeds: expected %v, got %v (%v)", exp, seeds, err)
+	}
+	if seeds, err = mi.WebSeeds(); err != nil || !reflect.DeepEqual(seeds, []string{"http://c/file"}) {
+		t.Errorf("WebSeeds: expected only the url-list, got %v (%v)", seeds, err)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if seeds, err = mi.HTTPSeeds(); err != nil || seeds == nil || len(seeds) != 0 {
+		t.Errorf("HTTPSeeds: expected empty slice, got %#v (%v)", seeds, err)
+	}
+
+	mi = &MetaInfo{parsed: map[string]interface{}{"httpseeds": "http://a/seed"}}
+	if _, err = mi.HTTPSeeds(); err == nil {
+		t.Errorf("HTTPSeeds: expected error for malformed httpseeds")
+	}
+}
+
+func TestSetDisplayName(t *testing.T) {
+	mi := &MetaInfo{}
+	if err := mi.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+	orig := hex.EncodeToString(mustInfoHash(t, mi))
+	if err := mi.SetDisplayName("renamed"); err != nil {
+		t.Fatalf("SetDisplayName: %v", err)
+	}
+	h := hex.EncodeToString(mustInfoHash(t, mi))
+	if h == orig {
+		t.Errorf("SetDisplayName: info hash didn't change")
+	}
+
+	filename := filepath.Join(t.TempDir(), "out.torrent")
+	if err := mi.WriteToFile(filename); err != nil {
+		t.Fatalf("WriteToFile: %v", err)
+	}
+	mi2 := &MetaInfo{}
+	if err := mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if name := mi2.Name(); name != "renamed" {
+		t.Errorf("SetDisplayName: expected name renamed after re-reading, got %q", name)
+	}
+	if h2 := hex.EncodeToString(mustInfoHash(t, mi2)); h2 != h {
+		t.Errorf("SetDisplayName: expected info hash %s after re-reading, got %s", h, h2)
+	}
+
+	for _, name := range []string{"", "..", "a/b"} {
+		if err := mi2.SetDisplayName(name); !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("SetDisplayName(%q): expected ErrUnsafePath, got %v", name, err)
+		}
+	}
+}
+
+func TestSimilarCollections(t *testing.T) {
+	a, b := strings.Repeat("a", 20), strings.Repeat("b", 20)
+	info := map[string]interface{}{
+		"name":         "x",
+		"length":       1,
+		"piece length": 1,
+		"pieces":       strings.Repeat("x", 20),
+		"similar":      []interface{}{a, b},
+		"collections":  []interface{}{"linux", "debian"},
+	}
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": info})
+	similar, err := mi.SimilarTorrents()
+	var ha, hb [20]byte
+	copy(ha[:], a)
+	copy(hb[:], b)
+	if exp := [][20]byte{ha, hb}; err != nil || !reflect.DeepEqual(similar, exp) {
+		t.Errorf("SimilarTorrents: expected %x, got %x (%v)", exp, similar, err)
+	}
+	collections, err := mi.Collections()
+	if exp := []string{"linux", "debian"}; err != nil || !reflect.DeepEqual(collections, exp) {
+		t.Errorf("Collections: expected %v, got %v (%v)", exp, collections, err)
+	}
+
+	delete(info, "collections")
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info, "collections": []interface{}{"root"}})
+	if collections, err = mi.Collections(); err != nil || !reflect.DeepEqual(collections, []string{"root"}) {
+		t.Errorf("Collections: expected the root key, got %v (%v)", collections, err)
+	}
+
+	delete(info, "similar")
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if similar, err = mi.SimilarTorrents(); err != nil || similar == nil || len(similar) != 0 {
+		t.Errorf("SimilarTorrents: expected empty slice, got %#v (%v)", similar, err)
+	}
+	if collections, err = mi.Collections(); err != nil || collections == nil || len(collections) != 0 {
+		t.Errorf("Collections: expected empty slice, got %#v (%v)", collections, err)
+	}
+
+	mi = &MetaInfo{parsed: map[string]interface{}{"similar": []interface{}{"short"}, "collections": "linux"}}
+	if _, err = mi.SimilarTorrents(); err == nil {
+		t.Errorf("SimilarTorrents: expected error for malformed info hash")
+	}
+	if _, err = mi.Collections(); err == nil {
+		t.Errorf("Collections: expected error for malformed collections")
+	}
+}
+
+func TestDHTNodes(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"nodes": []interface{}{
+			[]interface{}{"router.example.com", 6881},
+			[]interface{}{"10.0.0.1", 51413},
+		},
+		"info": info,
+	})
+	nodes, err := mi.DHTNodes()
+	if exp := []DHTNode{{"router.example.com", 6881}, {"10.0.0.1", 51413}}; err != nil || !reflect.DeepEqual(nodes, exp) {
+		t.Errorf("DHTNodes: expected %v, got %v (%v)", exp, nodes, err)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if nodes, err = mi.DHTNodes(); err != nil || nodes == nil || len(nodes) != 0 {
+		t.Errorf("DHTNodes: expected empty slice, got %#v (%v)", nodes, err)
+	}
+
+	for _, bad := range []interface{}{
+		"router.example.com:6881",
+		[]interface{}{"router.example.com:6881"},
+		[]interface{}{[]interface{}{"router.example.com"}},
+		[]interface{}{[]interface{}{int64(1), int64(6881)}},
+		[]interface{}{[]interface{}{"router.example.com", "6881"}},
+		[]interface{}{[]interface{}{"router.example.com", int64(65536)}},
+	} {
+		mi = &MetaInfo{parsed: map[string]interface{}{"nodes": bad}}
+		if _, err = mi.DHTNodes(); err == nil {
+			t.Errorf("DHTNodes: expected error for nodes %v", bad)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	info := map[string]interface{}{
+		"name":         "caf\xe9",
+		"name.utf-8":   "café",
+		"piece length": int64(1),
+		"pieces":       strings.Repeat("x", 40),
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(1), "path": []interface{}{"d\xe9j\xe0", "a"}, "path.utf-8": []interface{}{"déjà", "a"}},
+			map[string]interface{}{"length": int64(1), "path": []interface{}{"b"}, "path.utf-8": []interface{}{"\xff"}},
+		},
+	}
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if n := mi.Name(); n != "café" {
+		t.Errorf("Name: expected café, got %q", n)
+	}
+	if n := mi.RawName(); n != "caf\xe9" {
+		t.Errorf("RawName: expected caf\\xe9, got %q", n)
+	}
+	paths, err := mi.FilePaths()
+	if exp := [][]string{{"déjà", "a"}, {"b"}}; err != nil || !reflect.DeepEqual(paths, exp) {
+		t.Errorf("FilePaths: expected %q, got %q (%v)", exp, paths, err)
+	}
+	paths, err = mi.RawFilePaths()
+	if exp := [][]string{{"d\xe9j\xe0", "a"}, {"b"}}; err != nil || !reflect.DeepEqual(paths, exp) {
+		t.Errorf("RawFilePaths: expected %q, got %q (%v)", exp, paths, err)
+	}
+
+	info["name.utf-8"] = "\xff"
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if n := mi.Name(); n != "caf\xe9" {
+		t.Errorf("Name: expected fallback to name for invalid name.utf-8, got %q", n)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}})
+	if paths, err = mi.FilePaths(); err != nil || !reflect.DeepEqual(paths, [][]string{{"x"}}) {
+		t.Errorf("FilePaths: expected the name for a single file, got %q (%v)", paths, err)
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"publisher":       "Publisher Inc",
+		"publisher.utf-8": "Publisher Ünc",
+		"publisher-url":   "http://publisher.example/",
+		"info":            info,
+	})
+	if p := mi.Publisher(); p != "Publisher Ünc" {
+		t.Errorf("Publisher: expected Publisher Ünc, got %q", p)
+	}
+	if u := mi.PublisherURL(); u != "http://publisher.example/" {
+		t.Errorf("PublisherURL: expected http://publisher.example/, got %q", u)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"publisher": "Publisher Inc", "publisher.utf-8": "\xff", "info": info})
+	if p := mi.Publisher(); p != "Publisher Inc" {
+		t.Errorf("Publisher: expected fallback to publisher, got %q", p)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if p, u := mi.Publisher(), mi.PublisherURL(); p != "" || u != "" {
+		t.Errorf("Publisher: expected no publisher, got %q and %q", p, u)
+	}
+}
+
+func TestFileOffsets(t *testing.T) {
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(20), "path": []interface{}{"a"}},
+			map[string]interface{}{"length": int64(0), "path": []interface{}{"empty"}},
+			map[string]interface{}{"length": int64(30), "path": []interface{}{"b"}},
+		},
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 4*20),
+	}}}
+	ranges, err := mi.FileOffsets()
+	if err != nil {
+		t.Fatalf("FileOffsets: %v", err)
+	}
+	exp := []FileRange{
+		{Start: 0, End: 20, FirstPiece: 0, LastPiece: 1},
+		{Start: 20, End: 20, FirstPiece: 1, LastPiece: 0},
+		{Start: 20, End: 50, FirstPiece: 1, LastPiece: 3},
+	}
+	if !reflect.DeepEqual(ranges, exp) {
+		t.Errorf("FileOffsets: expected %v, got %v", exp, ranges)
+	}
+	if ranges[2].FirstPiece != ranges[0].LastPiece {
+		t.Errorf("FileOffsets: second file should start in the first file's last piece")
+	}
+
+	mi = &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"length":       int64(40),
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 3*20),
+	}}}
+	if ranges, err = mi.FileOffsets(); err != nil || !reflect.DeepEqual(ranges, []FileRange{{0, 40, 0, 2}}) {
+		t.Errorf("FileOffsets: unexpected single-file ranges %v (%v)", ranges, err)
+	}
+}
+
+func TestPiecesForFile(t *testing.T) {
+	//piece 1 (bytes 16-31) straddles the first and last file
+	mi := &MetaInfo{parsed: map[string]interface{}{"info": map[string]interface{}{
+		"files": []interface{}{
+			map[string]interface{}{"length": int64(20), "path": []interface{}{"a"}},
+			map[string]interface{}{"length": int64(0), "path": []interface{}{"empty"}},
+			map[string]interface{}{"length": int64(30), "path": []interface{}{"b"}},
+		},
+		"piece length": int64(16),
+		"pieces":       strings.Repeat("x", 4*20),
+	}}}
+	for i, exp := range [][]int{{0, 1}, {}, {1, 2, 3}} {
+		pieces, err := mi.PiecesForFile(i)
+		if err != nil || !reflect.DeepEqual(pieces, exp) {
+			t.Errorf("PiecesForFile(%d): expected %v, got %v (%v)", i, exp, pieces, err)
+		}
+	}
+	for _, i := range []int{-1, 3} {
+		if _, err := mi.PiecesForFile(i); err == nil {
+			t.Errorf("PiecesForFile(%d): expected error", i)
+		}
+	}
+}
+
+func TestSameContent(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	a := readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	b := readTestTorrentDict(t, map[string]interface{}{"announce": "http://b/announce", "comment": "hi", "info": info})
+	c := readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+
+	if same, err := a.SameContent(b); err != nil || !same {
+		t.Errorf("SameContent: expected same content (%v)", err)
+	}
+	if a.Equal(b) {
+		t.Errorf("Equal: torrents with different announce are equal")
+	}
+	if !a.Equal(c) {
+		t.Errorf("Equal: identical torrents are not equal")
+	}
+
+	info["name"] = "y"
+	d := readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	if same, err := a.SameContent(d); err != nil || same {
+		t.Errorf("SameContent: expected different content (%v)", err)
+	}
+	if _, err := a.SameContent(new(MetaInfo)); err == nil {
+		t.Errorf("SameContent: expected error for empty metainfo")
+	}
+}
+
+func TestHybridTorrent(t *testing.T) {
+	v1 := map[string]interface{}{
+		"name":         "x",
+		"length":       int64(5),
+		"piece length": int64(16384),
+		"pieces":       strings.Repeat("x", 20),
+	}
+	hybrid := map[string]interface{}{
+		"name":         "x",
+		"length":       int64(5),
+		"piece length": int64(16384),
+		"pieces":       strings.Repeat("x", 20),
+		"meta version": int64(2),
+		"file tree": map[string]interface{}{
+			"x": map[string]interface{}{"": map[string]interface{}{"length": int64(5), "pieces root": strings.Repeat("r", 32)}},
+		},
+	}
+	infoBytes := mustEncode(t, hybrid)
+
+	a := readTestTorrentDict(t, map[string]interface{}{"info": v1})
+	b := readTestTorrentDict(t, map[string]interface{}{"info": hybrid})
+	c := readTestTorrentDict(t, map[string]interface{}{"info": hybrid, "piece layers": map[string]interface{}{}})
+	if a.MetaVersion() != 1 || a.IsHybrid() {
+		t.Errorf("MetaVersion: expected v1 torrent")
+	}
+	if b.MetaVersion() != 2 || !b.IsHybrid() {
+		t.Errorf("MetaVersion: expected hybrid torrent")
+	}
+
+	exp := sha1.Sum(infoBytes)
+	if h := mustInfoHash(t, b); string(h) != string(exp[:]) {
+		t.Errorf("InfoHash: expected sha1 of the whole info dict for hybrid, got %x", h)
+	}
+	if string(mustInfoHash(t, b)) != string(mustInfoHash(t, c)) {
+		t.Errorf("InfoHash: piece layers changed the v1 info hash")
+	}
+
+	v2 := map[string]interface{}{
+		"name":         "x",
+		"piece length": int64(16384),
+		"meta version": int64(2),
+		"file tree":    hybrid["file tree"],
+	}
+	d := readTestTorrentDict(t, map[string]interface{}{"info": v2})
+	if d.MetaVersion() != 2 || d.IsHybrid() {
+		t.Errorf("MetaVersion: expected v2 only torrent")
+	}
+}
+
+func TestPieceLayers(t *testing.T) {
+	root := strings.Repeat("r", 32)
+	layer := strings.Repeat("a", 32) + strings.Repeat("b", 32)
+	info := map[string]interface{}{
+		"name":         "x",
+		"piece length": int64(16384),
+		"meta version": int64(2),
+		"file tree": map[string]interface{}{
+			"x": map[string]interface{}{"": map[string]interface{}{"length": int64(32768), "pieces root": root}},
+		},
+	}
+	mi := readTestTorrentDict(t, map[string]interface{}{"info": info, "piece layers": map[string]interface{}{root: layer}})
+	layers, err := mi.PieceLayers()
+	if exp := map[string][]byte{root: []byte(layer)}; err != nil || !reflect.DeepEqual(layers, exp) {
+		t.Errorf("PieceLayers: expected %x, got %x (%v)", exp, layers, err)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}})
+	if layers, err = mi.PieceLayers(); err != nil || layers == nil || len(layers) != 0 {
+		t.Errorf("PieceLayers: expected empty map for v1 torrent, got %v (%v)", layers, err)
+	}
+
+	for _, bad := range []interface{}{
+		layer,
+		map[string]interface{}{root: layer[1:]},
+		map[string]interface{}{root[1:]: layer},
+		map[string]interface{}{root: int64(1)},
+	} {
+		mi = &MetaInfo{parsed: map[string]interface{}{"piece layers": bad}}
+		if _, err = mi.PieceLayers(); err == nil {
+			t.Errorf("PieceLayers: expected error for %q", bad)
+		}
+	}
+}
+
+func TestAddTracker(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	hash := hex.EncodeToString(mustInfoHash(t, mi))
+
+	if err := mi.AddTracker("http://b/announce", 0); err != nil {
+		t.Fatalf("AddTracker: %v", err)
+	}
+	if err := mi.AddTracker("udp://c:80", 5); err != nil {
+		t.Fatalf("AddTracker: %v", err)
+	}
+	if err := mi.AddTracker("http://b/announce", 1); err == nil {
+		t.Errorf("AddTracker: expected error for duplicate tracker")
+	}
+
+	tiers, err := mi.AnnounceList()
+	exp := [][]string{{"http://a/announce", "http://b/announce"}, {"udp://c:80"}}
+	if err != nil || !reflect.DeepEqual(tiers, exp) {
+		t.Errorf("AddTracker: expected tiers %v, got %v (%v)", exp, tiers, err)
+	}
+	if h := hex.EncodeToString(mustInfoHash(t, mi)); h != hash {
+		t.Errorf("AddTracker: info hash changed from %s to %s", hash, h)
+	}
+
+	filename := filepath.Join(t.TempDir(), "out.torrent")
+	if err = mi.WriteToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	mi2 := &MetaInfo{}
+	if err = mi2.ReadFromFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if h := hex.EncodeToString(mustInfoHash(t, mi2)); h != hash {
+		t.Errorf("AddTracker: written info hash changed from %s to %s", hash, h)
+	}
+}
+
+func TestPromoteTracker(t *testing.T) {
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce": "http://a/announce",
+		"announce-list": []interface{}{
+			[]interface{}{"http://a/announce", "http://b/announce", "http://c/announce"},
+			[]interface{}{"http://d/announce", "http://e/announce"},
+		},
+		"info": info,
+	})
+	hash := mi.InfoHashHex()
+
+	if err := mi.PromoteTracker("http://c/announce"); err != nil {
+		t.Fatalf("PromoteTracker: %v", err)
+	}
+	if err := mi.PromoteTracker("http://e/announce"); err != nil {
+		t.Fatalf("PromoteTracker: %v", err)
+	}
+	if err := mi.PromoteTracker("http://c/announce"); err != nil {
+		t.Fatalf("PromoteTracker: %v", err)
+	}
+	tiers, _ := mi.AnnounceList()
+	exp := [][]string{
+		{"http://c/announce", "http://a/announce", "http://b/announce"},
+		{"http://e/announce", "http://d/announce"},
+	}
+	if !reflect.DeepEqual(tiers, exp) {
+		t.Errorf("PromoteTracker: expected %v, got %v", exp, tiers)
+	}
+	if h := mi.InfoHashHex(); h != hash {
+		t.Errorf("PromoteTracker: info hash changed from %s to %s", hash, h)
+	}
+
+	if err := mi.PromoteTracker("http://x/announce"); err == nil {
+		t.Errorf("PromoteTracker: expected error for unknown tracker")
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"announce": "http://a/announce", "info": info})
+	if err := mi.PromoteTracker("http://a/announce"); err != nil {
+		t.Errorf("PromoteTracker: %v", err)
+	}
+	if _, ok := mi.parsed["announce-list"]; ok {
+		t.Errorf("PromoteTracker: unexpected announce-list")
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	b, err := ioutil.ReadFile("test.torrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromFile := &MetaInfo{}
+	if err = fromFile.ReadFromFile("test.torrent"); err != nil {
+		t.Fatal(err)
+	}
+
+	var mi MetaInfo
+	var _ io.ReaderFrom = &mi
+	n, err := mi.ReadFrom(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	if n != int64(len(b)) {
+		t.Errorf("ReadFrom: expected %d bytes read, got %d", len(b), n)
+	}
+	if !mi.Equal(fromFile) || string(mustInfoHash(t, &mi)) != string(mustInfoHash(t, fromFile)) {
+		t.Errorf("ReadFrom: result differs from ReadFromFile")
+	}
+
+	if _, err = new(MetaInfo).ReadFrom(strings.NewReader("i5e")); err == nil {
+		t.Errorf("ReadFrom: expected error for non-dict torrent")
+	}
+}
+
+func TestInfoBytes(t *testing.T) {
+	//keys out of order, so a re-encoding would differ
+	info := "d6:pieces20:" + strings.Repeat("x", 20) + "4:name1:x12:piece lengthi1e6:lengthi1ee"
+	mi := readTestTorrent(t, "d4:info"+info+"e")
+	b, err := mi.InfoBytes()
+	if err != nil {
+		t.Fatalf("InfoBytes: %v", err)
+	}
+	if string(b) != info {
+		t.Errorf("InfoBytes: expected %s, got %s", info, b)
+	}
+	if h := sha1.Sum(b); string(h[:]) != string(mustInfoHash(t, mi)) {
+		t.Errorf("InfoBytes: sha1 differs from InfoHash")
+	}
+
+	if _, err = new(MetaInfo).InfoBytes(); err == nil {
+		t.Errorf("InfoBytes: expected error without info dict")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+//client prefix of our peer ids (Azureus style)
+const peerIDPrefix = "-GR0001-"
+
+//GeneratePeerID returns a peer id starting with prefix, e.g. "-GR0001-",
+//followed by random bytes.
+func GeneratePeerID(prefix string) (id [20]byte, err error) {
+	if len(prefix) > len(id) {
+		return id, errors.New("Peer id prefix longer than 20 bytes")
+	}
+	n := copy(id[:], prefix)
+	_, err = rand.Read(id[n:])
+	return
+}
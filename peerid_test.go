@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePeerID(t *testing.T) {
+	id1, err := GeneratePeerID(peerIDPrefix)
+	if err != nil {
+		t.Fatalf("GeneratePeerID: %v", err)
+	}
+	id2, err := GeneratePeerID(peerIDPrefix)
+	if err != nil {
+		t.Fatalf("GeneratePeerID: %v", err)
+	}
+	if len(id1) != 20 || !strings.HasPrefix(string(id1[:]), peerIDPrefix) {
+		t.Errorf("GeneratePeerID: prefix not preserved in %q", id1)
+	}
+	if id1 == id2 {
+		t.Errorf("GeneratePeerID: generated the same id twice")
+	}
+
+	if _, err = GeneratePeerID(strings.Repeat("x", 21)); err == nil {
+		t.Errorf("GeneratePeerID: expected error for long prefix")
+	}
+	if id, err := GeneratePeerID(strings.Repeat("x", 20)); err != nil || string(id[:]) != strings.Repeat("x", 20) {
+		t.Errorf("GeneratePeerID: unexpected result %q (%v)", id, err)
+	}
+}
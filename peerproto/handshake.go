@@ -0,0 +1,58 @@
+/*
+	Package peerproto implements the peer wire protocol spoken between
+	Bittorrent clients.
+
+*/
+package peerproto
+
+import (
+	"errors"
+	"io"
+)
+
+//Protocol is the protocol string sent in the handshake
+const Protocol = "BitTorrent protocol"
+
+var ErrorProtocol = errors.New("Peer doesn't speak the BitTorrent protocol")
+
+//WriteHandshake sends the handshake that opens a peer connection.
+//All reserved bits are zero.
+func WriteHandshake(w io.Writer, infoHash, peerID [20]byte) error {
+	b := make([]byte, 0, 1+len(Protocol)+8+20+20)
+	b = append(b, byte(len(Protocol)))
+	b = append(b, Protocol...)
+	b = append(b, make([]byte, 8)...)
+	b = append(b, infoHash[:]...)
+	b = append(b, peerID[:]...)
+	_, err := w.Write(b)
+	return err
+}
+
+//ReadHandshake reads the handshake sent by a peer.
+//It fails with ErrorProtocol if the protocol string isn't Protocol.
+func ReadHandshake(r io.Reader) (infoHash, peerID [20]byte, reserved [8]byte, err error) {
+	pstr := make([]byte, 1+len(Protocol))
+	if _, err = io.ReadFull(r, pstr[:1]); err != nil {
+		return
+	}
+	if int(pstr[0]) != len(Protocol) {
+		err = ErrorProtocol
+		return
+	}
+	if _, err = io.ReadFull(r, pstr[1:]); err != nil {
+		return
+	}
+	if string(pstr[1:]) != Protocol {
+		err = ErrorProtocol
+		return
+	}
+
+	b := make([]byte, 8+20+20)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return
+	}
+	copy(reserved[:], b[0:8])
+	copy(infoHash[:], b[8:28])
+	copy(peerID[:], b[28:48])
+	return
+}
@@ -0,0 +1,76 @@
+package peerproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//message ids
+const (
+	MsgChoke         = 0
+	MsgUnchoke       = 1
+	MsgInterested    = 2
+	MsgNotInterested = 3
+	MsgHave          = 4
+	MsgBitfield      = 5
+	MsgRequest       = 6
+	MsgPiece         = 7
+	MsgCancel        = 8
+)
+
+//messages longer than this are rejected to protect against peers making
+//us allocate huge buffers
+const maxMessageLength = 1 << 22
+
+var ErrorMessageTooLong = errors.New("Peer message too long")
+
+//Message is a message of the peer wire protocol.
+//A keep-alive is represented by a nil *Message.
+type Message struct {
+	ID      byte
+	Payload []byte
+}
+
+//ReadMessage reads the next length-prefixed message from r.
+//A keep-alive yields a nil message and a nil error.
+func ReadMessage(r io.Reader) (*Message, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxMessageLength {
+		return nil, ErrorMessageTooLong
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return &Message{ID: b[0], Payload: b[1:]}, nil
+}
+
+//WriteMessage writes m to w with its length prefix.
+//A nil message is sent as a keep-alive.
+func WriteMessage(w io.Writer, m *Message) error {
+	if m == nil {
+		_, err := w.Write(make([]byte, 4))
+		return err
+	}
+	if len(m.Payload)+1 > maxMessageLength {
+		return ErrorMessageTooLong
+	}
+	b := make([]byte, 5, 5+len(m.Payload))
+	binary.BigEndian.PutUint32(b, uint32(len(m.Payload)+1))
+	b[4] = m.ID
+	b = append(b, m.Payload...)
+	_, err := w.Write(b)
+	return err
+}
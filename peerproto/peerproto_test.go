@@ -0,0 +1,86 @@
+package peerproto
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	var infoHash, peerID [20]byte
+	copy(infoHash[:], "\x00\x01\x02info hash bytes!!")
+	copy(peerID[:], "-GR0001-123456789012")
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(WriteHandshake(w, infoHash, peerID))
+	}()
+	h, id, reserved, err := ReadHandshake(r)
+	if err != nil {
+		t.Fatalf("ReadHandshake: %v", err)
+	}
+	if h != infoHash || id != peerID || reserved != [8]byte{} {
+		t.Errorf("ReadHandshake: got %q %q %v", h, id, reserved)
+	}
+}
+
+func TestHandshakeBadProtocol(t *testing.T) {
+	for _, in := range []string{
+		"\x13BitTorrent protocoX" + string(make([]byte, 48)),
+		"\x04test" + string(make([]byte, 48)),
+	} {
+		if _, _, _, err := ReadHandshake(bytes.NewReader([]byte(in))); err != ErrorProtocol {
+			t.Errorf("ReadHandshake(%q): expected ErrorProtocol, got %v", in[:8], err)
+		}
+	}
+	var b bytes.Buffer
+	WriteHandshake(&b, [20]byte{}, [20]byte{})
+	if _, _, _, err := ReadHandshake(bytes.NewReader(b.Bytes()[:50])); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadHandshake: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	var b bytes.Buffer
+	piece := append([]byte{0, 0, 0, 3, 0, 0, 0x40, 0}, []byte("block data")...)
+	for _, m := range []*Message{
+		nil,
+		{ID: MsgHave, Payload: []byte{0, 0, 0, 42}},
+		{ID: MsgPiece, Payload: piece},
+		{ID: MsgInterested},
+	} {
+		if err := WriteMessage(&b, m); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	if !bytes.HasPrefix(b.Bytes(), []byte{0, 0, 0, 0, 0, 0, 0, 5, MsgHave, 0, 0, 0, 42}) {
+		t.Errorf("WriteMessage: unexpected encoding % x", b.Bytes()[:13])
+	}
+
+	m, err := ReadMessage(&b)
+	if err != nil || m != nil {
+		t.Errorf("ReadMessage: expected keep-alive, got %v (%v)", m, err)
+	}
+	m, err = ReadMessage(&b)
+	if err != nil || m.ID != MsgHave || !bytes.Equal(m.Payload, []byte{0, 0, 0, 42}) {
+		t.Errorf("ReadMessage: expected have message, got %v (%v)", m, err)
+	}
+	m, err = ReadMessage(&b)
+	if err != nil || m.ID != MsgPiece || !bytes.Equal(m.Payload, piece) {
+		t.Errorf("ReadMessage: expected piece message, got %v (%v)", m, err)
+	}
+	m, err = ReadMessage(&b)
+	if err != nil || m.ID != MsgInterested || len(m.Payload) != 0 {
+		t.Errorf("ReadMessage: expected interested message, got %v (%v)", m, err)
+	}
+	if _, err = ReadMessage(&b); err != io.EOF {
+		t.Errorf("ReadMessage: expected io.EOF, got %v", err)
+	}
+
+	if _, err = ReadMessage(bytes.NewReader([]byte{0, 0, 0, 5, MsgHave, 0})); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessage: expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err = ReadMessage(bytes.NewReader([]byte{0xff, 0, 0, 0})); err != ErrorMessageTooLong {
+		t.Errorf("ReadMessage: expected ErrorMessageTooLong, got %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorrent/bencode"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//tracker communication
+
+//BuildAnnounceURL returns the url of an HTTP announce request to the
+//tracker at announce. The binary info hash and peer id are percent-encoded
+//byte by byte. event is one of "started", "stopped", "completed" or "" for
+//a regular announce. trackerID is the tracker id of a previous response,
+//if there was one. The scheme of announce must be http, https or udp.
+func BuildAnnounceURL(announce string, infoHash [20]byte, peerID [20]byte, port int, uploaded, downloaded, left int64, event, trackerID string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	if announce == "" {
+		return "", errors.New("Empty announce url")
+	}
+	if err = checkTrackerScheme(u); err != nil {
+		return "", err
+	}
+	switch event {
+	case "", "started", "stopped", "completed":
+	default:
+		return "", errors.New("Invalid announce event: " + event)
+	}
+	if port < 0 || port > 65535 {
+		return "", errors.New("Invalid port: " + strconv.Itoa(port))
+	}
+
+	s := announce
+	if strings.Contains(s, "?") {
+		s += "&"
+	} else {
+		s += "?"
+	}
+	s += "info_hash=" + rfc1738_encode(string(infoHash[:]))
+	s += "&peer_id=" + rfc1738_encode(string(peerID[:]))
+	s += "&port=" + strconv.Itoa(port)
+	s += "&uploaded=" + strconv.FormatInt(uploaded, 10)
+	s += "&downloaded=" + strconv.FormatInt(downloaded, 10)
+	s += "&left=" + strconv.FormatInt(left, 10)
+	if event != "" {
+		s += "&event=" + event
+	}
+	if trackerID != "" {
+		s += "&trackerid=" + rfc1738_encode(trackerID)
+	}
+	return s, nil
+}
+
+//fail for trackers we can't talk to
+func checkTrackerScheme(u *url.URL) error {
+	switch u.Scheme {
+	case "http", "https", "udp":
+		return nil
+	case "":
+		return errors.New("Announce url has no scheme: " + u.String())
+	}
+	return fmt.Errorf("Unsupported announce url scheme %q", u.Scheme)
+}
+
+//AnnounceHTTP sends an announce request built by BuildAnnounceURL and
+//parses the tracker's response. The request is aborted when ctx is done,
+//in which case ctx.Err() is returned. A nil client means http.DefaultClient.
+func AnnounceHTTP(ctx context.Context, client *http.Client, announceURL string) (*AnnounceResponse, error) {
+	return announceHTTP(ctx, client, announceURL, nopLogger)
+}
+
+//AnnounceHTTP logging the response status and parse outcome to log
+func announceHTTP(ctx context.Context, client *http.Client, announceURL string, log *slog.Logger) (*AnnounceResponse, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", announceURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	log.Debug("Tracker responded", "status", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Announce failed: " + resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	ar, err := ParseAnnounceResponse(b)
+	if err != nil {
+		log.Debug("Couldn't parse tracker response", "error", err)
+		return nil, err
+	}
+	log.Debug("Parsed tracker response", "interval", ar.Interval, "peers", len(ar.Peers))
+	return ar, nil
+}
+
+//Stats are the transfer statistics and state sent with an announce.
+type Stats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string //"started", "stopped", "completed" or ""
+	TrackerID  string //tracker id of a previous response, if any
+}
+
+//A TrackerClient announces to the trackers of a torrent.
+type TrackerClient struct {
+	HTTPClient *http.Client  //nil means http.DefaultClient
+	UDPTimeout time.Duration //BaseTimeout of udp trackers, see UDPTracker
+
+	//receives announce attempts and their outcome at debug level.
+	//nil means nothing is logged.
+	Logger *slog.Logger
+}
+
+//a logger discarding everything
+var nopLogger = slog.New(slog.DiscardHandler)
+
+func (tc *TrackerClient) logger() *slog.Logger {
+	if tc.Logger == nil {
+		return nopLogger
+	}
+	return tc.Logger
+}
+
+//Announce announces with a TrackerClient using http.DefaultClient.
+func Announce(ctx context.Context, mi *MetaInfo, peerID [20]byte, port int, stats Stats) (*AnnounceResponse, error) {
+	return new(TrackerClient).Announce(ctx, mi, peerID, port, stats)
+}
+
+//Announce sends an announce for mi to its trackers and returns the first
+//successful response. The trackers are tried in announce-list order
+//(BEP 12): on failure the next tracker of the tier is tried and after
+//that the next tier. If all trackers fail, their errors are returned
+//together. A torrent without trackers yields ErrNoTracker.
+//udp trackers are announced to with a UDPTracker (BEP 15).
+func (tc *TrackerClient) Announce(ctx context.Context, mi *MetaInfo, peerID [20]byte, port int, stats Stats) (*AnnounceResponse, error) {
+	tiers, err := mi.AnnounceList()
+	if err != nil {
+		return nil, err
+	}
+	b, err := mi.InfoHash()
+	if err != nil {
+		return nil, err
+	}
+	var infoHash [20]byte
+	copy(infoHash[:], b)
+
+	log := tc.logger()
+	var errs []error
+	for i, tier := range tiers {
+		for _, announce := range tier {
+			log.Debug("Announcing", "tracker", announce, "tier", i, "event", stats.Event)
+			resp, err := tc.announce(ctx, announce, infoHash, peerID, port, stats)
+			if err == nil {
+				log.Debug("Announce succeeded", "tracker", announce)
+				return resp, nil
+			}
+			log.Debug("Announce failed", "tracker", announce, "error", err)
+			if err := contextErr(ctx); err != nil {
+				return nil, err
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", announce, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoTracker
+	}
+	return nil, errors.Join(errs...)
+}
+
+//announce to a single tracker, over UDP (BEP 15) for udp urls
+func (tc *TrackerClient) announce(ctx context.Context, announce string, infoHash, peerID [20]byte, port int, stats Stats) (*AnnounceResponse, error) {
+	u, err := BuildAnnounceURL(announce, infoHash, peerID, port, stats.Uploaded, stats.Downloaded, stats.Left, stats.Event, stats.TrackerID)
+	if err != nil {
+		return nil, err
+	}
+	if pu, _ := url.Parse(announce); pu.Scheme == "udp" {
+		tr, err := NewUDPTracker(announce)
+		if err != nil {
+			return nil, err
+		}
+		tr.BaseTimeout = tc.UDPTimeout
+		return tr.AnnounceContext(ctx, infoHash, peerID, port, stats.Uploaded, stats.Downloaded, stats.Left, stats.Event)
+	}
+	return announceHTTP(ctx, tc.HTTPClient, u, tc.logger())
+}
+
+//ctx.Err(), or context.DeadlineExceeded once the deadline of ctx has
+//passed even if its timer hasn't fired yet. network deadlines are capped
+//at it, so they may expire right before ctx does.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+//Peer is a peer in the swarm as reported by a tracker.
+type Peer struct {
+	ID   [20]byte //zero if the tracker didn't send it
+	IP   net.IP
+	Port uint16
+}
+
+//String returns the address of the peer as host:port, with IPv6 hosts in
+//brackets. Being canonical for an address it can serve as map key to
+//remove duplicate peers.
+func (p Peer) String() string {
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
+}
+
+//TCPAddr returns the address to dial the peer at.
+func (p Peer) TCPAddr() *net.TCPAddr {
+	return &net.TCPAddr{IP: p.IP, Port: int(p.Port)}
+}
+
+//Equal reports whether both peers have the same address. Peer ids aren't
+//compared since compact peer lists don't carry them.
+func (p Peer) Equal(other Peer) bool {
+	return p.IP.Equal(other.IP) && p.Port == other.Port
+}
+
+//AnnounceResponse is the result of a successful announce.
+type AnnounceResponse struct {
+	Interval    int    //seconds to wait between regular announces
+	MinInterval int    //minimum seconds between announces, 0 if not given
+	TrackerID   string //to be sent back with the next announce, if not empty
+	Warning     string //warning message of the tracker, if any
+	Peers       []Peer
+}
+
+//ParseAnnounceResponse decodes the bencoded response of an HTTP tracker.
+//A "failure reason" sent by the tracker is returned as an error.
+func ParseAnnounceResponse(data []byte) (*AnnounceResponse, error) {
+	o, err := bencode.NewDecoder(data).Decode()
+	if err != nil {
+		return nil, errors.New("Couldn't parse announce response: " + err.Error())
+	}
+	d, ok := o.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Announce response is not a dict")
+	}
+	if reason, ok := d["failure reason"]; ok {
+		return nil, fmt.Errorf("Tracker failure: %v", reason)
+	}
+
+	resp := new(AnnounceResponse)
+	interval, ok := d["interval"].(int64)
+	if !ok {
+		return nil, errors.New("Announce response has no interval")
+	}
+	resp.Interval = int(interval)
+	if i, ok := d["min interval"].(int64); ok {
+		resp.MinInterval = int(i)
+	}
+	if id, ok := d["tracker id"].(string); ok {
+		resp.TrackerID = id
+	}
+	if w, ok := d["warning message"].(string); ok {
+		resp.Warning = w
+	}
+
+	switch peers := d["peers"].(type) {
+	case nil:
+	case string:
+		if resp.Peers, err = parseCompactPeers(peers, net.IPv4len); err != nil {
+			return nil, err
+		}
+	case []interface{}:
+		if resp.Peers, err = parsePeerDicts(peers); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Invalid peers in announce response")
+	}
+
+	switch peers6 := d["peers6"].(type) {
+	case nil:
+	case string:
+		p6, err := parseCompactPeers(peers6, net.IPv6len)
+		if err != nil {
+			return nil, err
+		}
+		resp.Peers = append(resp.Peers, p6...)
+	default:
+		return nil, errors.New("Invalid peers6 in announce response")
+	}
+	return resp, nil
+}
+
+//parse the compact peer list (BEP 23). every entry is an ip address of
+//iplen bytes followed by a 2-byte big-endian port.
+func parseCompactPeers(s string, iplen int) ([]Peer, error) {
+	n := iplen + 2
+	if len(s)%n != 0 {
+		return nil, fmt.Errorf("Compact peer list length %d is not a multiple of %d", len(s), n)
+	}
+	peers := make([]Peer, 0, len(s)/n)
+	for i := 0; i < len(s); i += n {
+		var p Peer
+		p.IP = net.IP([]byte(s[i : i+iplen]))
+		p.Port = uint16(s[i+iplen])<<8 | uint16(s[i+iplen+1])
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+//parse the dictionary model peer list. ip and port are required,
+//peer id is optional. peers with a dns name instead of an ip address,
+//which BEP 3 allows, are left out since Peer only holds addresses.
+func parsePeerDicts(list []interface{}) ([]Peer, error) {
+	peers := make([]Peer, 0, len(list))
+	for _, o := range list {
+		d, ok := o.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Peer entry is not a dict")
+		}
+		var p Peer
+
+		//the peer id is optional, some trackers leave it out
+		if o, ok := d["peer id"]; ok {
+			id, ok := o.(string)
+			if !ok || len(id) != len(p.ID) {
+				return nil, errors.New("Invalid peer id in peer entry")
+			}
+			copy(p.ID[:], id)
+		}
+
+		ip, ok := d["ip"].(string)
+		if !ok || ip == "" {
+			return nil, errors.New("No ip in peer entry")
+		}
+		port, ok := d["port"].(int64)
+		if !ok || port < 0 || port > 65535 {
+			return nil, errors.New("Invalid port in peer entry")
+		}
+		p.Port = uint16(port)
+		if p.IP = net.ParseIP(ip); p.IP == nil {
+			continue //a dns name
+		}
+
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+//ScrapeStats holds the swarm statistics of a torrent reported by a scrape.
+type ScrapeStats struct {
+	Complete   int //number of seeders
+	Incomplete int //number of leechers
+	Downloaded int //number of completed downloads
+}
+
+//ScrapeURL derives the scrape url from an announce url by replacing the
+//"announce" at the start of the last path segment with "scrape".
+func ScrapeURL(announce string) (string, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || !strings.HasPrefix(u.Path[i+1:], "announce") {
+		return "", errors.New("Tracker doesn't support scraping: " + announce)
+	}
+	u.Path = u.Path[:i+1] + "scrape" + u.Path[i+1+len("announce"):]
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+//Scrape requests the swarm statistics of the given torrents from an
+//HTTP tracker. The result is keyed by info hash.
+func Scrape(announce string, infoHashes ...[20]byte) (map[[20]byte]ScrapeStats, error) {
+	s, err := ScrapeURL(announce)
+	if err != nil {
+		return nil, err
+	}
+	sep := "?"
+	if strings.Contains(s, "?") {
+		sep = "&"
+	}
+	for _, h := range infoHashes {
+		s += sep + "info_hash=" + rfc1738_encode(string(h[:]))
+		sep = "&"
+	}
+
+	resp, err := http.Get(s)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Scrape failed: " + resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return ParseScrapeResponse(b)
+}
+
+//ParseScrapeResponse decodes the bencoded response of a scrape request.
+func ParseScrapeResponse(data []byte) (map[[20]byte]ScrapeStats, error) {
+	o, err := bencode.NewDecoder(data).Decode()
+	if err != nil {
+		return nil, errors.New("Couldn't parse scrape response: " + err.Error())
+	}
+	d, ok := o.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Scrape response is not a dict")
+	}
+	if reason, ok := d["failure reason"]; ok {
+		return nil, fmt.Errorf("Tracker failure: %v", reason)
+	}
+	files, ok := d["files"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Scrape response has no files dict")
+	}
+
+	stats := make(map[[20]byte]ScrapeStats, len(files))
+	for k, v := range files {
+		var h [20]byte
+		if len(k) != len(h) {
+			return nil, errors.New("Invalid info hash in scrape response")
+		}
+		copy(h[:], k)
+		f, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("Invalid file entry in scrape response")
+		}
+		var st ScrapeStats
+		if i, ok := f["complete"].(int64); ok {
+			st.Complete = int(i)
+		}
+		if i, ok := f["incomplete"].(int64); ok {
+			st.Incomplete = int(i)
+		}
+		if i, ok := f["downloaded"].(int64); ok {
+			st.Downloaded = int(i)
+		}
+		stats[h] = st
+	}
+	return stats, nil
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildAnnounceURL(t *testing.T) {
+	var infoHash, peerID [20]byte
+	infoHash[0] = 0x00
+	infoHash[1] = 0x25
+	infoHash[2] = 'a'
+	infoHash[3] = 0xff
+	copy(peerID[:], "-GR0001-123456789012")
+
+	s, err := BuildAnnounceURL("http://tracker/announce", infoHash, peerID, 6881, 1, 2, 3, "started", "")
+	if err != nil {
+		t.Fatalf("BuildAnnounceURL: %v", err)
+	}
+	exp := "http://tracker/announce?info_hash=%00%25a%FF" + strings.Repeat("%00", 16) +
+		"&peer_id=-GR0001-123456789012&port=6881&uploaded=1&downloaded=2&left=3&event=started"
+	if s != exp {
+		t.Errorf("BuildAnnounceURL: expected\n%s\ngot\n%s", exp, s)
+	}
+
+	s, err = BuildAnnounceURL("http://tracker/announce?key=x", infoHash, peerID, 6881, 0, 0, 0, "", "abc 1")
+	if err != nil {
+		t.Fatalf("BuildAnnounceURL: %v", err)
+	}
+	if !strings.HasPrefix(s, "http://tracker/announce?key=x&info_hash=") || strings.Contains(s, "event=") ||
+		!strings.HasSuffix(s, "&trackerid=abc%201") {
+		t.Errorf("BuildAnnounceURL: unexpected url %s", s)
+	}
+
+	if _, err = BuildAnnounceURL("http://tracker/announce", infoHash, peerID, 6881, 0, 0, 0, "paused", ""); err == nil {
+		t.Errorf("BuildAnnounceURL: expected error for invalid event")
+	}
+}
+
+func TestParseAnnounceResponse(t *testing.T) {
+	id := "-GR0001-123456789012"
+	data := mustEncode(t, map[string]interface{}{
+		"interval": 1800,
+		"peers": []interface{}{
+			map[string]interface{}{"peer id": id, "ip": "10.0.0.1", "port": 6881},
+			map[string]interface{}{"peer id": id, "ip": "::1", "port": 51413},
+		},
+	})
+	resp, err := ParseAnnounceResponse(data)
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	if resp.Interval != 1800 {
+		t.Errorf("ParseAnnounceResponse: expected interval 1800, got %d", resp.Interval)
+	}
+	if len(resp.Peers) != 2 {
+		t.Fatalf("ParseAnnounceResponse: expected 2 peers, got %d", len(resp.Peers))
+	}
+	p := resp.Peers[0]
+	if string(p.ID[:]) != id || !p.IP.Equal(net.IPv4(10, 0, 0, 1)) || p.Port != 6881 {
+		t.Errorf("ParseAnnounceResponse: unexpected peer %v", p)
+	}
+	if p = resp.Peers[1]; !p.IP.Equal(net.IPv6loopback) || p.Port != 51413 {
+		t.Errorf("ParseAnnounceResponse: unexpected peer %v", p)
+	}
+
+	data = mustEncode(t, map[string]interface{}{"failure reason": "torrent not registered"})
+	if _, err = ParseAnnounceResponse(data); err == nil || !strings.Contains(err.Error(), "torrent not registered") {
+		t.Errorf("ParseAnnounceResponse: expected failure reason error, got %v", err)
+	}
+}
+
+func TestParseCompactPeers(t *testing.T) {
+	peers := "\x0a\x00\x00\x01\x1a\xe1" + "\xc0\xa8\x01\x02\x00\x50" + "\x7f\x00\x00\x01\xc8\xd5"
+	peers6 := "\x20\x01\x0d\xb8" + strings.Repeat("\x00", 11) + "\x01\x1a\xe1"
+	data := mustEncode(t, map[string]interface{}{"interval": 60, "peers": peers, "peers6": peers6})
+	resp, err := ParseAnnounceResponse(data)
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	exp := []Peer{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 6881},
+		{IP: net.IPv4(192, 168, 1, 2), Port: 80},
+		{IP: net.IPv4(127, 0, 0, 1), Port: 51413},
+		{IP: net.ParseIP("2001:db8::1"), Port: 6881},
+	}
+	if len(resp.Peers) != len(exp) {
+		t.Fatalf("ParseAnnounceResponse: expected %d peers, got %d", len(exp), len(resp.Peers))
+	}
+	for i, p := range resp.Peers {
+		if !p.IP.Equal(exp[i].IP) || p.Port != exp[i].Port {
+			t.Errorf("ParseAnnounceResponse: expected peer %v, got %v", exp[i], p)
+		}
+	}
+
+	data = mustEncode(t, map[string]interface{}{"interval": 60, "peers": "\x0a\x00\x00\x01\x1a"})
+	if _, err = ParseAnnounceResponse(data); err == nil {
+		t.Errorf("ParseAnnounceResponse: expected error for malformed compact peers")
+	}
+}
+
+func TestScrapeURL(t *testing.T) {
+	for in, exp := range map[string]string{
+		"http://example.com/announce":          "http://example.com/scrape",
+		"http://example.com/x/announce":        "http://example.com/x/scrape",
+		"http://example.com/announce.php":      "http://example.com/scrape.php",
+		"http://example.com/announce?x2%0644":  "http://example.com/scrape?x2%0644",
+		"http://example.com/announce?key=1234": "http://example.com/scrape?key=1234",
+		"http://example.com/a":                 "",
+		"http://example.com/announce?x=2/4":    "http://example.com/scrape?x=2/4",
+		"http://example.com/x%064announce":     "",
+		"http://example.com/announce/x":        "",
+	} {
+		s, err := ScrapeURL(in)
+		if exp == "" {
+			if err == nil {
+				t.Errorf("ScrapeURL(%s): expected error, got %s", in, s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ScrapeURL(%s): unexpected error %v", in, err)
+		} else if s != exp {
+			t.Errorf("ScrapeURL(%s): expected %s, got %s", in, exp, s)
+		}
+	}
+}
+
+func TestScrape(t *testing.T) {
+	var h1, h2 [20]byte
+	h1[0] = 0x25
+	h2[0] = 0xff
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/scrape" || len(r.URL.Query()["info_hash"]) != 2 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write(mustEncode(t, map[string]interface{}{
+			"files": map[string]interface{}{
+				string(h1[:]): map[string]interface{}{"complete": 5, "incomplete": 3, "downloaded": 50},
+				string(h2[:]): map[string]interface{}{"complete": 1, "incomplete": 0, "downloaded": 2},
+			},
+		}))
+	}))
+	defer srv.Close()
+
+	stats, err := Scrape(srv.URL+"/announce", h1, h2)
+	if err != nil {
+		t.Fatalf("Scrape: %v", err)
+	}
+	if st := stats[h1]; st != (ScrapeStats{5, 3, 50}) {
+		t.Errorf("Scrape: unexpected stats %v", st)
+	}
+	if st := stats[h2]; st != (ScrapeStats{1, 0, 2}) {
+		t.Errorf("Scrape: unexpected stats %v", st)
+	}
+}
+
+func TestAnnounceHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("port") != "6881" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Write(mustEncode(t, map[string]interface{}{"interval": 900, "peers": "\x0a\x00\x00\x01\x1a\xe1"}))
+	}))
+	defer srv.Close()
+
+	var infoHash, peerID [20]byte
+	u, err := BuildAnnounceURL(srv.URL+"/announce", infoHash, peerID, 6881, 0, 0, 0, "started", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := AnnounceHTTP(context.Background(), nil, u)
+	if err != nil {
+		t.Fatalf("AnnounceHTTP: %v", err)
+	}
+	if resp.Interval != 900 || len(resp.Peers) != 1 {
+		t.Errorf("AnnounceHTTP: unexpected response %v", resp)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = AnnounceHTTP(ctx, nil, u); err != context.Canceled {
+		t.Errorf("AnnounceHTTP: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestAnnounceHTTPTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := AnnounceHTTP(ctx, nil, srv.URL+"/announce"); err != context.DeadlineExceeded {
+		t.Errorf("AnnounceHTTP: expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("AnnounceHTTP: timeout took %v", d)
+	}
+}
+
+func TestParseAnnounceResponseTrackerFields(t *testing.T) {
+	resp, err := ParseAnnounceResponse(mustEncode(t, map[string]interface{}{
+		"interval":     1800,
+		"min interval": 900,
+		"tracker id":   "xyz",
+		"peers":        "",
+	}))
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	if resp.MinInterval != 900 || resp.TrackerID != "xyz" {
+		t.Errorf("ParseAnnounceResponse: unexpected min interval %d and tracker id %q", resp.MinInterval, resp.TrackerID)
+	}
+
+	resp, err = ParseAnnounceResponse(mustEncode(t, map[string]interface{}{"interval": 1800, "peers": ""}))
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	if resp.MinInterval != 0 || resp.TrackerID != "" {
+		t.Errorf("ParseAnnounceResponse: unexpected min interval %d and tracker id %q", resp.MinInterval, resp.TrackerID)
+	}
+}
+
+func TestParseAnnounceResponseWarning(t *testing.T) {
+	resp, err := ParseAnnounceResponse(mustEncode(t, map[string]interface{}{
+		"interval":        1800,
+		"warning message": "client version is outdated",
+		"peers":           "\x0a\x00\x00\x01\x1a\xe1",
+	}))
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	if resp.Warning != "client version is outdated" {
+		t.Errorf("ParseAnnounceResponse: unexpected warning %q", resp.Warning)
+	}
+	if len(resp.Peers) != 1 || resp.Peers[0].Port != 6881 {
+		t.Errorf("ParseAnnounceResponse: unexpected peers %v", resp.Peers)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	var failed int
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed++
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	var query url.Values
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write(mustEncode(t, map[string]interface{}{"interval": 1800, "peers": "\x0a\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x1a\xe2"}))
+	}))
+	defer good.Close()
+
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce": bad.URL + "/announce",
+		"announce-list": []interface{}{
+			[]interface{}{bad.URL + "/announce", good.URL + "/announce"},
+		},
+		"info": info,
+	})
+	var peerID [20]byte
+	copy(peerID[:], "-GR0001-abcdefghijkl")
+	tc := &TrackerClient{HTTPClient: good.Client()}
+	resp, err := tc.Announce(context.Background(), mi, peerID, 6881, Stats{Left: 1, Event: "started"})
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if failed != 1 {
+		t.Errorf("Announce: expected 1 request to the failing tracker, got %d", failed)
+	}
+	if resp.Interval != 1800 || len(resp.Peers) != 2 || resp.Peers[1].Port != 6882 {
+		t.Errorf("Announce: unexpected response %v", resp)
+	}
+	if query.Get("info_hash") != string(mustInfoHash(t, mi)) || query.Get("left") != "1" || query.Get("event") != "started" {
+		t.Errorf("Announce: unexpected query %v", query)
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"announce": bad.URL + "/announce", "info": info})
+	if _, err = Announce(context.Background(), mi, peerID, 6881, Stats{}); err == nil {
+		t.Errorf("Announce: expected error when all trackers fail")
+	}
+
+	mi = readTestTorrentDict(t, map[string]interface{}{"info": info})
+	if _, err = Announce(context.Background(), mi, peerID, 6881, Stats{}); !errors.Is(err, ErrNoTracker) {
+		t.Errorf("Announce: expected ErrNoTracker, got %v", err)
+	}
+}
+
+//collects the messages and attributes of log records
+type captureHandler struct {
+	events *[]string
+}
+
+func (h captureHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h captureHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h captureHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h captureHandler) Handle(_ context.Context, r slog.Record) error {
+	s := r.Message
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key != "error" {
+			s += " " + a.String()
+		}
+		return true
+	})
+	*h.events = append(*h.events, s)
+	return nil
+}
+
+func TestAnnounceLogger(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(mustEncode(t, map[string]interface{}{"interval": 1800, "peers": "\x0a\x00\x00\x01\x1a\xe1"}))
+	}))
+	defer good.Close()
+
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce-list": []interface{}{
+			[]interface{}{bad.URL},
+			[]interface{}{good.URL},
+		},
+		"info": map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)},
+	})
+	var events []string
+	tc := &TrackerClient{HTTPClient: good.Client(), Logger: slog.New(captureHandler{&events})}
+	if _, err := tc.Announce(context.Background(), mi, [20]byte{}, 6881, Stats{Event: "started"}); err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	exp := []string{
+		"Announcing tracker=" + bad.URL + " tier=0 event=started",
+		"Tracker responded status=503",
+		"Announce failed tracker=" + bad.URL,
+		"Announcing tracker=" + good.URL + " tier=1 event=started",
+		"Tracker responded status=200",
+		"Parsed tracker response interval=1800 peers=1",
+		"Announce succeeded tracker=" + good.URL,
+	}
+	if strings.Join(events, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("Announce: logged\n%s\nexpected\n%s", strings.Join(events, "\n"), strings.Join(exp, "\n"))
+	}
+}
+
+func TestPeerAddr(t *testing.T) {
+	v4 := Peer{IP: net.IP{10, 0, 0, 1}, Port: 6881}
+	v6 := Peer{IP: net.ParseIP("2001:db8::1"), Port: 51413}
+	if s := v4.String(); s != "10.0.0.1:6881" {
+		t.Errorf("String: expected 10.0.0.1:6881, got %s", s)
+	}
+	if s := v6.String(); s != "[2001:db8::1]:51413" {
+		t.Errorf("String: expected [2001:db8::1]:51413, got %s", s)
+	}
+	if a := v6.TCPAddr(); !a.IP.Equal(v6.IP) || a.Port != 51413 || a.String() != v6.String() {
+		t.Errorf("TCPAddr: unexpected address %v", a)
+	}
+
+	//the same address from a compact list and a dict list, in 16-byte form
+	mapped := Peer{IP: net.ParseIP("10.0.0.1"), Port: 6881}
+	mapped.ID[0] = 1
+	if !v4.Equal(mapped) || v4.Equal(v6) || v4.Equal(Peer{IP: v4.IP, Port: 6882}) {
+		t.Errorf("Equal: unexpected result")
+	}
+	seen := make(map[string]Peer)
+	for _, p := range []Peer{v4, v6, mapped, v6} {
+		seen[p.String()] = p
+	}
+	if len(seen) != 2 {
+		t.Errorf("String: expected 2 distinct peers, got %v", seen)
+	}
+}
+
+func TestParseAnnounceResponseNoPeerID(t *testing.T) {
+	data := mustEncode(t, map[string]interface{}{
+		"interval": 1800,
+		"peers": []interface{}{
+			map[string]interface{}{"ip": "10.0.0.1", "port": 6881},
+			map[string]interface{}{"ip": "peer.example.com", "port": 6881}, //skipped
+			map[string]interface{}{"ip": "10.0.0.2", "port": 6882},
+		},
+	})
+	resp, err := ParseAnnounceResponse(data)
+	if err != nil {
+		t.Fatalf("ParseAnnounceResponse: %v", err)
+	}
+	if len(resp.Peers) != 2 || resp.Peers[1].String() != "10.0.0.2:6882" {
+		t.Fatalf("ParseAnnounceResponse: unexpected peers %v", resp.Peers)
+	}
+	for _, p := range resp.Peers {
+		if p.ID != [20]byte{} {
+			t.Errorf("ParseAnnounceResponse: expected empty peer id, got %x", p.ID)
+		}
+	}
+
+	for _, peer := range []map[string]interface{}{
+		{"port": 6881},
+		{"ip": "10.0.0.1"},
+		{"ip": "peer.example.com"},
+		{"peer id": "short", "ip": "10.0.0.1", "port": 6881},
+	} {
+		data = mustEncode(t, map[string]interface{}{"interval": 1800, "peers": []interface{}{peer}})
+		if _, err = ParseAnnounceResponse(data); err == nil {
+			t.Errorf("ParseAnnounceResponse: expected error for peer %v", peer)
+		}
+	}
+}
+
+func TestBuildAnnounceURLScheme(t *testing.T) {
+	var infoHash, peerID [20]byte
+	for _, c := range []struct {
+		announce string
+		ok       bool
+	}{
+		{"http://tracker/announce", true},
+		{"https://tracker/announce", true},
+		{"HTTPS://tracker/announce", true},
+		{"udp://tracker:6969/announce", true},
+		{"javascript:alert(1)", false},
+		{"ftp://tracker/announce", false},
+		{"tracker/announce", false},
+		{"//tracker/announce", false},
+	} {
+		_, err := BuildAnnounceURL(c.announce, infoHash, peerID, 6881, 0, 0, 0, "", "")
+		if c.ok && err != nil {
+			t.Errorf("BuildAnnounceURL(%s): %v", c.announce, err)
+		} else if !c.ok && err == nil {
+			t.Errorf("BuildAnnounceURL(%s): expected error", c.announce)
+		}
+	}
+}
+
+func TestAnnounceUDP(t *testing.T) {
+	conn := fakeUDPTracker(t, []byte{10, 0, 0, 1, 0x1a, 0xe1})
+	defer conn.Close()
+
+	info := map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)}
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce-list": []interface{}{
+			[]interface{}{"ftp://tracker/announce", "udp://" + conn.LocalAddr().String() + "/announce"},
+		},
+		"info": info,
+	})
+	var peerID [20]byte
+	tc := &TrackerClient{UDPTimeout: 50 * time.Millisecond}
+	resp, err := tc.Announce(context.Background(), mi, peerID, 6881, Stats{})
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if resp.Interval != 1800 || len(resp.Peers) != 1 || resp.Peers[0].String() != "10.0.0.1:6881" {
+		t.Errorf("Announce: unexpected response %v", resp)
+	}
+}
+
+func TestAnnounceUDPCancel(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	mi := readTestTorrentDict(t, map[string]interface{}{
+		"announce": "udp://" + conn.LocalAddr().String(),
+		"info":     map[string]interface{}{"name": "x", "length": 1, "piece length": 1, "pieces": strings.Repeat("x", 20)},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err = Announce(ctx, mi, [20]byte{}, 6881, Stats{}); err != context.DeadlineExceeded {
+		t.Errorf("Announce: expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Announce: took %v to reach the deadline of a udp announce", d)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"time"
+)
+
+//UDP tracker protocol (BEP 15)
+
+const (
+	udpProtocolID = 0x41727101980
+
+	udpActionConnect  = 0
+	udpActionAnnounce = 1
+	udpActionError    = 3
+
+	//how long a connection id may be used
+	udpConnectionTTL = time.Minute
+)
+
+//UDPTracker talks to a tracker using the UDP tracker protocol.
+//Requests are retransmitted after BaseTimeout * 2^n for n = 0..MaxRetries.
+type UDPTracker struct {
+	BaseTimeout time.Duration //15 seconds if zero
+	MaxRetries  int           //8 if zero
+
+	addr     string
+	connID   uint64
+	connTime time.Time
+	key      uint32
+}
+
+//NewUDPTracker creates a client for the tracker at announce, which must be
+//a udp:// url.
+func NewUDPTracker(announce string) (*UDPTracker, error) {
+	u, err := url.Parse(announce)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" {
+		return nil, errors.New("Not a udp tracker url: " + announce)
+	}
+	if u.Port() == "" {
+		return nil, errors.New("No port in udp tracker url: " + announce)
+	}
+	return &UDPTracker{addr: u.Host, key: rand.Uint32()}, nil
+}
+
+//Announce sends an announce request, connecting first if there is no
+//valid connection id.
+func (tr *UDPTracker) Announce(infoHash [20]byte, peerID [20]byte, port int, uploaded, downloaded, left int64, event string) (*AnnounceResponse, error) {
+	return tr.AnnounceContext(context.Background(), infoHash, peerID, port, uploaded, downloaded, left, event)
+}
+
+//AnnounceContext is Announce aborting when ctx is done, in which case
+//ctx.Err() is returned. No retransmission waits past the deadline of ctx.
+func (tr *UDPTracker) AnnounceContext(ctx context.Context, infoHash [20]byte, peerID [20]byte, port int, uploaded, downloaded, left int64, event string) (*AnnounceResponse, error) {
+	var ev uint32
+	switch event {
+	case "":
+	case "completed":
+		ev = 1
+	case "started":
+		ev = 2
+	case "stopped":
+		ev = 3
+	default:
+		return nil, errors.New("Invalid announce event: " + event)
+	}
+	if port < 0 || port > 65535 {
+		return nil, errors.New("Invalid port")
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", tr.addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer conn.Close()
+	//closing the conn interrupts a pending read
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if tr.connID == 0 || time.Since(tr.connTime) > udpConnectionTTL {
+		if err = tr.connect(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	req := make([]byte, 98)
+	binary.BigEndian.PutUint64(req[0:], tr.connID)
+	binary.BigEndian.PutUint32(req[8:], udpActionAnnounce)
+	copy(req[16:], infoHash[:])
+	copy(req[36:], peerID[:])
+	binary.BigEndian.PutUint64(req[56:], uint64(downloaded))
+	binary.BigEndian.PutUint64(req[64:], uint64(left))
+	binary.BigEndian.PutUint64(req[72:], uint64(uploaded))
+	binary.BigEndian.PutUint32(req[80:], ev)
+	binary.BigEndian.PutUint32(req[84:], 0) //ip: let the tracker decide
+	binary.BigEndian.PutUint32(req[88:], tr.key)
+	binary.BigEndian.PutUint32(req[92:], 0xffffffff) //num_want: default
+	binary.BigEndian.PutUint16(req[96:], uint16(port))
+
+	b, err := tr.roundTrip(ctx, conn, req, udpActionAnnounce)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 20 {
+		return nil, errors.New("Short udp announce response")
+	}
+
+	iplen := net.IPv4len
+	if raddr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && raddr.IP.To4() == nil {
+		iplen = net.IPv6len
+	}
+	resp := &AnnounceResponse{Interval: int(binary.BigEndian.Uint32(b[8:]))}
+	if resp.Peers, err = parseCompactPeers(string(b[20:]), iplen); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+//obtain a connection id from the tracker
+func (tr *UDPTracker) connect(ctx context.Context, conn net.Conn) error {
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint64(req[0:], udpProtocolID)
+	binary.BigEndian.PutUint32(req[8:], udpActionConnect)
+
+	b, err := tr.roundTrip(ctx, conn, req, udpActionConnect)
+	if err != nil {
+		return err
+	}
+	if len(b) < 16 {
+		return errors.New("Short udp connect response")
+	}
+	tr.connID = binary.BigEndian.Uint64(b[8:])
+	tr.connTime = time.Now()
+	return nil
+}
+
+//send req with a fresh transaction id and wait for the matching response,
+//retransmitting on timeout. returns the whole response packet, or
+//ctx.Err() once ctx is done.
+func (tr *UDPTracker) roundTrip(ctx context.Context, conn net.Conn, req []byte, action uint32) ([]byte, error) {
+	base, retries := tr.BaseTimeout, tr.MaxRetries
+	if base <= 0 {
+		base = 15 * time.Second
+	}
+	if retries <= 0 {
+		retries = 8
+	}
+
+	tid := rand.Uint32()
+	binary.BigEndian.PutUint32(req[12:], tid)
+
+	buf := make([]byte, 2048)
+	for n := 0; n <= retries; n++ {
+		if err := contextErr(ctx); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(req); err != nil {
+			if err := contextErr(ctx); err != nil {
+				return nil, err
+			}
+			return nil, err
+		}
+		deadline := time.Now().Add(base << uint(n))
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			m, err := conn.Read(buf)
+			if err != nil {
+				if err := contextErr(ctx); err != nil {
+					return nil, err
+				}
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if m < 8 || binary.BigEndian.Uint32(buf[4:]) != tid {
+				continue //not ours
+			}
+			switch binary.BigEndian.Uint32(buf[0:]) {
+			case action:
+				return append([]byte(nil), buf[:m]...), nil
+			case udpActionError:
+				return nil, errors.New("Tracker failure: " + string(buf[8:m]))
+			default:
+				return nil, errors.New("Unexpected action in udp tracker response")
+			}
+		}
+	}
+	if err := contextErr(ctx); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("Udp tracker timed out")
+}
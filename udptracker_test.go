@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+//a fake udp tracker answering connect and announce requests.
+//the first packet it receives is dropped to exercise retransmission.
+func fakeUDPTracker(t *testing.T, peers []byte) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		dropped := false
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if !dropped {
+				dropped = true
+				continue
+			}
+			tid := buf[12:16]
+			switch {
+			case n == 16 && binary.BigEndian.Uint64(buf) == udpProtocolID:
+				resp := make([]byte, 16)
+				binary.BigEndian.PutUint32(resp[0:], udpActionConnect)
+				copy(resp[4:], tid)
+				binary.BigEndian.PutUint64(resp[8:], 0xdeadbeef)
+				conn.WriteTo(resp, addr)
+			case n == 98 && binary.BigEndian.Uint64(buf) == 0xdeadbeef:
+				resp := make([]byte, 20)
+				binary.BigEndian.PutUint32(resp[0:], udpActionAnnounce)
+				copy(resp[4:], tid)
+				binary.BigEndian.PutUint32(resp[8:], 1800)
+				binary.BigEndian.PutUint32(resp[12:], 1)
+				binary.BigEndian.PutUint32(resp[16:], 2)
+				conn.WriteTo(append(resp, peers...), addr)
+			default:
+				resp := make([]byte, 8)
+				binary.BigEndian.PutUint32(resp[0:], udpActionError)
+				copy(resp[4:], tid)
+				conn.WriteTo(append(resp, "bad request"...), addr)
+			}
+		}
+	}()
+	return conn
+}
+
+func TestUDPTrackerAnnounce(t *testing.T) {
+	conn := fakeUDPTracker(t, []byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2})
+	defer conn.Close()
+
+	tr, err := NewUDPTracker("udp://" + conn.LocalAddr().String() + "/announce")
+	if err != nil {
+		t.Fatalf("NewUDPTracker: %v", err)
+	}
+	tr.BaseTimeout = 50 * time.Millisecond
+	tr.MaxRetries = 2
+
+	var infoHash, peerID [20]byte
+	resp, err := tr.Announce(infoHash, peerID, 6881, 0, 0, 100, "started")
+	if err != nil {
+		t.Fatalf("Announce: %v", err)
+	}
+	if resp.Interval != 1800 {
+		t.Errorf("Announce: expected interval 1800, got %d", resp.Interval)
+	}
+	if len(resp.Peers) != 2 || !resp.Peers[1].IP.Equal(net.IPv4(10, 0, 0, 2)) || resp.Peers[1].Port != 6882 {
+		t.Errorf("Announce: unexpected peers %v", resp.Peers)
+	}
+
+	if _, err = NewUDPTracker("http://tracker/announce"); err == nil {
+		t.Errorf("NewUDPTracker: expected error for http url")
+	}
+}
+
+func TestUDPTrackerTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tr, err := NewUDPTracker("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("NewUDPTracker: %v", err)
+	}
+	tr.BaseTimeout = 10 * time.Millisecond
+	tr.MaxRetries = 1
+
+	var infoHash, peerID [20]byte
+	if _, err = tr.Announce(infoHash, peerID, 6881, 0, 0, 0, ""); err == nil {
+		t.Errorf("Announce: expected timeout error")
+	}
+}
+
+func TestUDPTrackerCancel(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tr, err := NewUDPTracker("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("NewUDPTracker: %v", err)
+	}
+
+	//the default timeouts would wait for hours
+	var infoHash, peerID [20]byte
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err = tr.AnnounceContext(ctx, infoHash, peerID, 6881, 0, 0, 0, ""); err != context.Canceled {
+		t.Errorf("AnnounceContext: expected context.Canceled, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("AnnounceContext: took %v to notice the cancellation", d)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err = tr.AnnounceContext(ctx, infoHash, peerID, 6881, 0, 0, 0, ""); err != context.DeadlineExceeded {
+		t.Errorf("AnnounceContext: expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("AnnounceContext: took %v to reach the deadline", d)
+	}
+}